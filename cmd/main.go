@@ -6,11 +6,19 @@ import (
 	"oidc-bridge/handler"
 	"oidc-bridge/service"
 	"oidc-bridge/utils"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// validate 子命令只校验配置，不启动服务
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
 	utils.InfoLogger.Println("Starting OIDC Bridge service")
 
 	// 定义命令行参数
@@ -25,20 +33,43 @@ func main() {
 		utils.ErrorLogger.Fatalf("Failed to load config: %v", err)
 	}
 
-	// 2. 初始化 Redis 或内存缓存
-	service.InitRedis()
+	// 2. 初始化存储（Redis、SQL 或内存）
+	if err := service.InitStore(); err != nil {
+		utils.ErrorLogger.Fatalf("Failed to init store: %v", err)
+	}
 
-	// 3. 初始化 Gin
+	// 3. 检查签名密钥，按配置自动生成
+	if err := service.EnsureSigningKey(); err != nil {
+		utils.ErrorLogger.Fatalf("Failed to prepare signing key: %v", err)
+	}
+	if err := service.InitKeyManager(); err != nil {
+		utils.ErrorLogger.Fatalf("Failed to load signing keys: %v", err)
+	}
+
+	// 4. 初始化 Gin
 	r := gin.Default()
 
-	// 4. 注册路由
-	r.GET("/.well-known/openid-configuration", handler.HandleDiscovery)
-	r.GET("/authorize", handler.HandleAuthorize)
-	r.POST("/token", handler.HandleToken)
-	r.GET("/userinfo", handler.HandleUserInfo)
-	r.GET("/.well-known/jwks.json", handler.HandleJWKS)
+	// 5. 注册路由
+	handler.RegisterProviders(r)
+	for _, provider := range config.Current().Providers {
+		utils.InfoLogger.Printf("Serving provider %s at %s%s", provider.Name, provider.Host, provider.PathPrefix)
+	}
+	r.GET("/healthz", handler.HandleHealth)
+
+	// 6. 配置文件变化或收到 SIGHUP 时重新加载配置，重载失败时继续使用原有配置
+	if _, err := config.WatchConfig(func() { _ = service.ReloadConfig() }); err != nil {
+		utils.ErrorLogger.Printf("Failed to watch config file, reload with SIGHUP only: %v", err)
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			utils.InfoLogger.Println("Received SIGHUP, reloading config")
+			_ = service.ReloadConfig()
+		}
+	}()
 
-	// 5. 启动服务
+	// 7. 启动服务
 	serverAddr := ":" + *port
 	utils.InfoLogger.Printf("Server starting on port %s", serverAddr)
 	if err := r.Run(serverAddr); err != nil {
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"oidc-bridge/config"
+	"oidc-bridge/service"
+	"os"
+)
+
+// runValidate 实现 validate 子命令：校验配置文件和签名密钥后退出，不启动服务，便于在 CI 中检查配置
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "Path to config file")
+	privateKeyPath := flags.String("private-key", "", "Path to private key file")
+	publicKeyPath := flags.String("public-key", "", "Path to public key file")
+	_ = flags.Parse(args)
+
+	// 1. 解析并校验配置
+	if err := config.LoadConfig(*configFile, *privateKeyPath, *publicKeyPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Config is invalid: %v\n", err)
+		return 1
+	}
+
+	// 2. 检查签名密钥与签名算法
+	if err := service.CheckSigningKeys(config.Current()); err != nil {
+		fmt.Fprintf(os.Stderr, "Signing keys are invalid: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Config is valid: %s\n", config.ConfigFile())
+	return 0
+}
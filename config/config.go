@@ -1,14 +1,40 @@
 package config
 
 import (
+	"fmt"
 	"oidc-bridge/model"
 	"oidc-bridge/utils"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/spf13/viper"
 )
 
-var AppConfig *model.Config
+// current 当前生效的配置，热重载时整体替换；处理请求时应只读取一次并在整个请求中使用同一份配置
+var current atomic.Pointer[model.Config]
+
+// loadOptions 启动时使用的配置文件和密钥路径参数，重新加载配置时沿用
+var loadOptions struct {
+	configFile     string
+	privateKeyPath string
+	publicKeyPath  string
+}
+
+// Current 返回当前生效的配置
+func Current() *model.Config {
+	return current.Load()
+}
+
+// SetCurrent 替换当前生效的配置，已经开始处理的请求继续使用原有配置
+func SetCurrent(cfg *model.Config) {
+	current.Store(cfg)
+}
+
+// ConfigFile 返回启动时加载的配置文件路径
+func ConfigFile() string {
+	return loadOptions.configFile
+}
 
 func LoadConfig(configFile, privateKeyPath, publicKeyPath string) error {
 	// 检查环境变量是否指定了配置文件路径
@@ -19,47 +45,181 @@ func LoadConfig(configFile, privateKeyPath, publicKeyPath string) error {
 		utils.DebugLogger.Printf("Loading config from file: %s", configFile)
 	}
 
-	// 使用 viper 加载配置文件
-	viper.SetConfigFile(configFile)
+	cfg, err := parseConfig(configFile, privateKeyPath, publicKeyPath)
+	if err != nil {
+		return err
+	}
+	loadOptions.configFile = configFile
+	loadOptions.privateKeyPath = privateKeyPath
+	loadOptions.publicKeyPath = publicKeyPath
+	SetCurrent(cfg)
+
+	utils.InfoLogger.Println("Configuration loaded successfully")
+	return nil
+}
+
+// ReadConfig 按启动时的参数重新读取并校验配置文件，返回新的配置但不替换当前配置
+func ReadConfig() (*model.Config, error) {
+	if loadOptions.configFile == "" {
+		return nil, fmt.Errorf("no config file loaded")
+	}
+	return parseConfig(loadOptions.configFile, loadOptions.privateKeyPath, loadOptions.publicKeyPath)
+}
 
-	if err := viper.ReadInConfig(); err != nil {
+// parseConfig 读取配置文件并解析提供方，应用环境变量和命令行参数的覆盖后校验配置
+func parseConfig(configFile, privateKeyPath, publicKeyPath string) (*model.Config, error) {
+	// 使用独立的 viper 实例加载配置文件，重新加载失败时不影响当前配置
+	v := viper.New()
+	v.SetConfigFile(configFile)
+
+	if err := v.ReadInConfig(); err != nil {
 		utils.ErrorLogger.Printf("Failed to read config file: %v", err)
-		return err
+		return nil, err
 	}
 
 	utils.InfoLogger.Println("Config file loaded successfully")
 
-	AppConfig = &model.Config{}
-	if err := viper.Unmarshal(AppConfig); err != nil {
-		utils.ErrorLogger.Printf("Failed to unmarshal config: %v", err)
-		return err
+	// 展开配置文件中的 ${VAR} 和 ${file:...} 引用，再应用 OIDC_BRIDGE_ 前缀的环境变量
+	v, err := resolveSettings(v)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to resolve config: %v", err)
+		return nil, err
 	}
 
 	// 优先级：命令行参数 > 环境变量 > 配置文件
-	// 先处理环境变量，若有值则覆盖配置文件中的设置
+	// 覆盖写入顶层设置，在合并提供方之前生效，未单独设置这些项的提供方同样继承覆盖后的值
+	// 先处理兼容的环境变量，若有值则覆盖配置文件和 OIDC_BRIDGE_ 环境变量中的设置
 	if envPrivateKeyPath := os.Getenv("PRIVATE_KEY_PATH"); envPrivateKeyPath != "" {
-		AppConfig.PrivateKeyPath = envPrivateKeyPath
+		v.Set("private_key_path", envPrivateKeyPath)
 		utils.DebugLogger.Printf("Private key path overridden by environment variable: %s", envPrivateKeyPath)
 	}
 	if envPublicKeyPath := os.Getenv("PUBLIC_KEY_PATH"); envPublicKeyPath != "" {
-		AppConfig.PublicKeyPath = envPublicKeyPath
+		v.Set("public_key_path", envPublicKeyPath)
 		utils.DebugLogger.Printf("Public key path overridden by environment variable: %s", envPublicKeyPath)
 	}
 	if envRedisAddr := os.Getenv("REDIS_ADDR"); envRedisAddr != "" {
-		AppConfig.RedisAddr = envRedisAddr
+		v.Set("redis_addr", envRedisAddr)
 		utils.DebugLogger.Printf("Redis address overridden by environment variable: %s", envRedisAddr)
 	}
 
 	// 再处理命令行参数，若有值则覆盖环境变量和配置文件中的设置
 	if privateKeyPath != "" {
-		AppConfig.PrivateKeyPath = privateKeyPath
+		v.Set("private_key_path", privateKeyPath)
 		utils.DebugLogger.Printf("Private key path overridden by command-line argument: %s", privateKeyPath)
 	}
 	if publicKeyPath != "" {
-		AppConfig.PublicKeyPath = publicKeyPath
+		v.Set("public_key_path", publicKeyPath)
 		utils.DebugLogger.Printf("Public key path overridden by command-line argument: %s", publicKeyPath)
 	}
 
-	utils.InfoLogger.Println("Configuration loaded successfully")
+	cfg := &model.Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		utils.ErrorLogger.Printf("Failed to unmarshal config: %v", err)
+		return nil, err
+	}
+	if err := loadProviders(v, cfg); err != nil {
+		utils.ErrorLogger.Printf("Failed to load providers: %v", err)
+		return nil, err
+	}
+
+	// 最后校验合并后的配置，在启动阶段暴露配置问题而不是在处理请求时才失败
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadProviders 解析 providers 列表，每个提供方以顶层配置为默认值，提供方中设置的键整体替换顶层的值
+// 提供方通过 provider 选择自己的预设时，预设涉及的配置项不再从顶层继承，而是取预设与提供方设置合并后的值
+// 未设置 host 和 path_prefix 的提供方以 /<name> 为路径前缀
+func loadProviders(v *viper.Viper, cfg *model.Config) error {
+	raw := v.Get("providers")
+	if raw == nil {
+		return nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("providers must be a list")
+	}
+
+	base := v.AllSettings()
+	delete(base, "providers")
+	presetSettings := presetKeys()
+
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for i, item := range items {
+		settings, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("providers[%d] must be a map", i)
+		}
+
+		own := make(map[string]interface{}, len(settings))
+		for key, value := range settings {
+			own[strings.ToLower(key)] = value
+		}
+		preset, _ := own[presetKey].(string)
+		own, err := applyPreset(own)
+		if err != nil {
+			return fmt.Errorf("providers[%d]: %w", i, err)
+		}
+
+		merged := make(map[string]interface{}, len(base)+len(own))
+		for key, value := range base {
+			if preset != "" && presetSettings[key] {
+				continue
+			}
+			merged[key] = value
+		}
+		for key, value := range own {
+			merged[key] = value
+		}
+
+		providerViper := viper.New()
+		if err := providerViper.MergeConfigMap(merged); err != nil {
+			return err
+		}
+		provider := &model.Config{}
+		if err := providerViper.Unmarshal(provider); err != nil {
+			return fmt.Errorf("providers[%d]: %w", i, err)
+		}
+
+		if provider.Name == "" {
+			return fmt.Errorf("providers[%d]: name is required", i)
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("duplicate provider name: %s", provider.Name)
+		}
+		names[provider.Name] = true
+
+		if provider.PathPrefix == "" && provider.Host == "" {
+			provider.PathPrefix = "/" + provider.Name
+		}
+		if provider.PathPrefix != "" {
+			provider.PathPrefix = "/" + strings.Trim(provider.PathPrefix, "/")
+			if provider.PathPrefix == "/" {
+				return fmt.Errorf("provider %s: path_prefix must not be /, use host to serve a provider at the root", provider.Name)
+			}
+			if prefixes[provider.Host+provider.PathPrefix] {
+				return fmt.Errorf("duplicate path_prefix for provider %s: %s", provider.Name, provider.PathPrefix)
+			}
+			prefixes[provider.Host+provider.PathPrefix] = true
+		}
+		cfg.Providers = append(cfg.Providers, provider)
+	}
 	return nil
 }
+
+// ProviderConfigs 返回 cfg 中所有对外提供服务的配置
+// 未配置 providers 时为顶层配置；配置了 providers 时，顶层配置只有设置了 op_authorize_url 才作为根路径下的默认提供方
+func ProviderConfigs(cfg *model.Config) []*model.Config {
+	if len(cfg.Providers) == 0 {
+		return []*model.Config{cfg}
+	}
+
+	var configs []*model.Config
+	if cfg.OPAuthURL != "" {
+		configs = append(configs, cfg)
+	}
+	return append(configs, cfg.Providers...)
+}
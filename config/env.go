@@ -0,0 +1,192 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"oidc-bridge/utils"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix 覆盖配置项的环境变量前缀
+const EnvPrefix = "OIDC_BRIDGE_"
+
+// envKeySeparator 环境变量名中分隔嵌套层级的分隔符，配置键本身包含单个下划线
+const envKeySeparator = "__"
+
+// interpolationPattern 匹配配置值中的 ${VAR}、${VAR:-default} 和 ${file:/path}，$${ 表示字面量 ${
+// 只有变量名合法的引用才会展开，密码等值中偶然出现的 ${ 后跟其他字符时保持原样
+var interpolationPattern = regexp.MustCompile(`\$?\$\{(file:[^}]*|[A-Za-z_][A-Za-z0-9_]*(:-[^}]*)?)\}`)
+
+// resolveSettings 展开配置文件中的引用并应用环境变量，再以 provider 指定的预设为默认值，返回基于结果的新 viper 实例
+// 环境变量设置的 map 和列表整体替换配置文件中的值
+func resolveSettings(v *viper.Viper) (*viper.Viper, error) {
+	settings := v.AllSettings()
+	if _, err := interpolateValue(settings); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(settings); err != nil {
+		return nil, err
+	}
+	settings, err := applyPreset(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := viper.New()
+	if err := resolved.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// applyEnvOverrides 将 OIDC_BRIDGE_ 前缀的环境变量覆盖到配置上
+// 变量名去掉前缀后转为小写，以双下划线分隔嵌套层级：OIDC_BRIDGE_REDIS__ADDRS 对应 redis.addrs，
+// OIDC_BRIDGE_PROVIDERS__LARK__ISSUER 对应名为 lark 的提供方的 issuer
+// 以 { 或 [ 开头的值按 JSON 解析，用于设置整个 map 或列表
+func applyEnvOverrides(settings map[string]interface{}) error {
+	overrides := make(map[string]string)
+	var names []string
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || len(name) == len(EnvPrefix) {
+			continue
+		}
+		overrides[name] = value
+		names = append(names, name)
+	}
+	// 按变量名排序，先覆盖整个 map 再覆盖其中的键
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, EnvPrefix)), envKeySeparator)
+		value, err := parseEnvValue(overrides[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if path[0] == "providers" && len(path) > 1 {
+			if len(path) == 2 {
+				return fmt.Errorf("%s: use %sPROVIDERS__<NAME>__<KEY> to override a provider", name, EnvPrefix)
+			}
+			if err := overrideProvider(settings, path[1], path[2:], value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		} else {
+			setNested(settings, path, value)
+		}
+		utils.DebugLogger.Printf("Config %s overridden by environment variable %s", strings.Join(path, "."), name)
+	}
+	return nil
+}
+
+// parseEnvValue 解析环境变量的值，JSON 对象和数组用于 map 和列表，其余按字符串处理
+func parseEnvValue(value string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return value, nil
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(trimmed), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid JSON value: %v", err)
+	}
+	return parsed, nil
+}
+
+// overrideProvider 覆盖 providers 列表中指定名称的提供方的配置，名称不区分大小写，- 写作 _
+func overrideProvider(settings map[string]interface{}, name string, path []string, value interface{}) error {
+	items, _ := settings["providers"].([]interface{})
+	for _, item := range items {
+		provider, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		providerName, _ := provider["name"].(string)
+		if strings.ReplaceAll(strings.ToLower(providerName), "-", "_") != name {
+			continue
+		}
+		setNested(provider, path, value)
+		return nil
+	}
+	return fmt.Errorf("no provider named %s", name)
+}
+
+// setNested 按路径设置嵌套 map 中的值，已有的键不区分大小写
+func setNested(settings map[string]interface{}, path []string, value interface{}) {
+	key := path[0]
+	for existing := range settings {
+		if strings.EqualFold(existing, key) {
+			key = existing
+			break
+		}
+	}
+	if len(path) == 1 {
+		settings[key] = value
+		return
+	}
+	child, ok := settings[key].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		settings[key] = child
+	}
+	setNested(child, path[1:], value)
+}
+
+// interpolateValue 递归展开 map 和列表中所有字符串里的环境变量和文件引用，map 和列表原地修改
+func interpolateValue(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return interpolate(typed)
+	case map[string]interface{}:
+		for key, item := range typed {
+			expanded, err := interpolateValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			typed[key] = expanded
+		}
+	case []interface{}:
+		for i, item := range typed {
+			expanded, err := interpolateValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			typed[i] = expanded
+		}
+	}
+	return value, nil
+}
+
+// interpolate 展开字符串中的引用：${VAR} 为环境变量，${VAR:-default} 在变量未设置或为空时使用默认值，
+// ${file:/path} 为文件内容（去掉末尾换行），适合引用 Kubernetes Secret 等挂载的密钥文件
+func interpolate(value string) (string, error) {
+	var firstErr error
+	expanded := interpolationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		expr := match[2 : len(match)-1]
+
+		if path, ok := strings.CutPrefix(expr, "file:"); ok {
+			data, err := os.ReadFile(path)
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to read referenced file: %v", err)
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+
+		name, fallback, hasDefault := strings.Cut(expr, ":-")
+		if envValue, ok := os.LookupEnv(name); ok && (envValue != "" || !hasDefault) {
+			return envValue
+		}
+		if !hasDefault && firstErr == nil {
+			firstErr = fmt.Errorf("environment variable %s is not set, use ${%s:-default} to provide a default or $${%s} for a literal ${%s}", name, name, name, name)
+		}
+		return fallback
+	})
+	return expanded, firstErr
+}
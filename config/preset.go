@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// presetFS 内置的平台预设，每个文件是一份只包含 OP 相关设置的配置
+//
+//go:embed presets/*.yaml
+var presetFS embed.FS
+
+// presetKey 选择平台预设的配置项
+const presetKey = "provider"
+
+// PresetNames 返回所有内置预设的名称
+func PresetNames() []string {
+	entries, _ := presetFS.ReadDir("presets")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadPreset 读取名为 name 的预设，每次返回新的 map
+func loadPreset(name string) (map[string]interface{}, error) {
+	data, err := presetFS.ReadFile(path.Join("presets", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown provider preset %q, use one of %s", name, strings.Join(PresetNames(), ", "))
+	}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("provider preset %s: %w", name, err)
+	}
+	return v.AllSettings(), nil
+}
+
+// presetKeys 返回任一预设设置的配置项，提供方选择了自己的预设时不再从顶层配置继承这些项
+func presetKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, name := range PresetNames() {
+		preset, err := loadPreset(name)
+		if err != nil {
+			continue
+		}
+		for key := range preset {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// applyPreset 以 settings 中 provider 指定的预设为默认值，返回合并后的配置；未指定预设时原样返回
+// settings 中的标量和列表替换预设的值，map 按键递归合并；user_attribute_mapping 按 claim 合并，
+// 配置文件将某个属性映射到 sub 时替换预设中映射到 sub 的属性
+func applyPreset(settings map[string]interface{}) (map[string]interface{}, error) {
+	name, _ := settings[presetKey].(string)
+	if name == "" {
+		return settings, nil
+	}
+	merged, err := loadPreset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range settings {
+		override, isMap := value.(map[string]interface{})
+		base, baseIsMap := merged[key].(map[string]interface{})
+		if isMap && baseIsMap && key == "user_attribute_mapping" {
+			merged[key] = mergeAttributeMapping(base, override)
+			continue
+		}
+		merged[key] = mergeValue(merged[key], value)
+	}
+	return merged, nil
+}
+
+// mergeValue 将 override 合并到预设的值 base 上，两者都是 map 时按键递归合并，否则以 override 为准
+func mergeValue(base, override interface{}) interface{} {
+	baseMap, baseIsMap := base.(map[string]interface{})
+	overrideMap, isMap := override.(map[string]interface{})
+	if !baseIsMap || !isMap {
+		return override
+	}
+	for key, value := range overrideMap {
+		baseMap[key] = mergeValue(baseMap[key], value)
+	}
+	return baseMap
+}
+
+// mergeAttributeMapping 合并属性映射，去掉预设中被 override 映射到相同 claim 的属性
+func mergeAttributeMapping(preset, override map[string]interface{}) map[string]interface{} {
+	claims := make(map[interface{}]bool, len(override))
+	for _, claim := range override {
+		claims[claim] = true
+	}
+	merged := make(map[string]interface{}, len(preset)+len(override))
+	for attr, claim := range preset {
+		if !claims[claim] {
+			merged[attr] = claim
+		}
+	}
+	for attr, claim := range override {
+		merged[attr] = claim
+	}
+	return merged
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce 合并编辑器保存或 ConfigMap 更新时短时间内产生的多个文件事件
+const reloadDebounce = 500 * time.Millisecond
+
+// CheckReload 检查新配置能否热重载
+// 路径前缀下的提供方路由在启动时注册，增删这类提供方或修改其 path_prefix、host 需要重启
+func CheckReload(old, cfg *model.Config) error {
+	routes := func(cfg *model.Config) map[string]string {
+		prefixed := make(map[string]string)
+		for _, provider := range cfg.Providers {
+			if provider.PathPrefix != "" {
+				prefixed[provider.Name] = provider.Host + provider.PathPrefix
+			}
+		}
+		return prefixed
+	}
+	if !reflect.DeepEqual(routes(old), routes(cfg)) {
+		return fmt.Errorf("providers served under a path_prefix changed, restart required")
+	}
+	return nil
+}
+
+// Diff 返回两份配置之间发生变化的配置项，以 mapstructure 键路径表示（提供方为 providers.<name>.<key>）
+// 只列出键名而不输出配置值，避免在日志中泄露密钥
+func Diff(old, cfg *model.Config) []string {
+	oldValues := make(map[string]interface{})
+	newValues := make(map[string]interface{})
+	flattenConfig("", reflect.ValueOf(old), oldValues)
+	flattenConfig("", reflect.ValueOf(cfg), newValues)
+
+	var changed []string
+	for key, value := range oldValues {
+		if newValue, ok := newValues[key]; !ok || !reflect.DeepEqual(value, newValue) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range newValues {
+		if _, ok := oldValues[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// flattenConfig 将配置展开为键路径到值的映射，结构体和字符串键的 map 逐层展开，列表作为整体比较
+func flattenConfig(prefix string, value reflect.Value, out map[string]interface{}) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			flattenConfig(prefix, value.Elem(), out)
+		}
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			if providers, ok := value.Field(i).Interface().([]*model.Config); ok {
+				for _, provider := range providers {
+					flattenConfig(joinKey(prefix, "providers."+provider.Name), reflect.ValueOf(provider), out)
+				}
+				continue
+			}
+			name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+			if name == "" || name == "-" {
+				name = strings.ToLower(field.Name)
+			}
+			flattenConfig(joinKey(prefix, name), value.Field(i), out)
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			out[prefix] = value.Interface()
+			return
+		}
+		for _, key := range value.MapKeys() {
+			flattenConfig(joinKey(prefix, key.String()), value.MapIndex(key), out)
+		}
+	default:
+		if !value.IsZero() {
+			out[prefix] = value.Interface()
+		}
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// WatchConfig 监听配置文件所在目录，配置文件被修改、替换或通过符号链接更新（如 Kubernetes ConfigMap）时调用 onChange
+// 返回的函数用于停止监听
+func WatchConfig(onChange func()) (func(), error) {
+	configFile := filepath.Clean(ConfigFile())
+	if configFile == "." {
+		return nil, fmt.Errorf("no config file loaded")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", filepath.Dir(configFile), err)
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					if timer != nil {
+						timer.Stop()
+					}
+					return
+				}
+				path := filepath.Clean(event.Name)
+				if path != configFile && filepath.Base(path) != "..data" {
+					continue
+				}
+				utils.DebugLogger.Printf("Config file changed: %s", event.Name)
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDebounce, onChange)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				utils.ErrorLogger.Printf("Config watcher error: %v", err)
+			}
+		}
+	}()
+	return func() { watcher.Close() }, nil
+}
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"oidc-bridge/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// signingAlgs 支持的 ID Token 签名算法，与 service.SigningMethod 保持一致
+var signingAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "EdDSA"}
+
+// ValidationError 配置校验发现的全部问题，一次列出以便逐项修正
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid config: " + e.Problems[0]
+	}
+	return fmt.Sprintf("invalid config (%d problems):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// validator 收集校验问题，prefix 标明问题所属的提供方
+type validator struct {
+	prefix   string
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, v.prefix+fmt.Sprintf(format, args...))
+}
+
+// Validate 校验配置的完整性：必填项、URL 格式、时长、取值范围、属性映射和客户端注册表
+// 存储相关的配置只在顶层校验，其余配置按每个对外提供服务的提供方分别校验
+// 签名密钥能否加载以及与签名算法是否匹配由 service.CheckSigningKeys 检查
+func Validate(cfg *model.Config) error {
+	v := &validator{}
+	v.validateStore(cfg)
+	for _, provider := range ProviderConfigs(cfg) {
+		v.prefix = ""
+		if provider.Name != "" {
+			v.prefix = "provider " + provider.Name + ": "
+		}
+		v.validateProvider(provider)
+		v.validateAttributeMapping(provider)
+		v.validateClients(provider)
+	}
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validateProvider 检查 OP 端点、Issuer、时长和签名设置
+func (v *validator) validateProvider(cfg *model.Config) {
+	v.requireURL("op_authorize_url", cfg.OPAuthURL, "the OP's OAuth2 authorization endpoint")
+	v.requireURL("op_token_url", cfg.OPTokenURL, "the OP's OAuth2 token endpoint")
+	v.requireURL("op_userinfo_url", cfg.OPUserInfoURL, "the OP's userinfo endpoint")
+	if cfg.Issuer != "" {
+		if parsed, err := url.Parse(cfg.Issuer); err != nil || parsed.Scheme != "https" && parsed.Scheme != "http" || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			v.addf("issuer %q must be an http(s) URL without query or fragment", cfg.Issuer)
+		}
+	}
+	if cfg.CallbackURL != "" {
+		v.checkURL("callback_url", cfg.CallbackURL)
+	}
+	switch cfg.OPDialect {
+	case "", "dingtalk":
+	case "wecom":
+		// 企业微信的登录页需要应用的 agentid，预设无法提供
+		if parsed, err := url.Parse(cfg.OPAuthURL); cfg.OPAuthURL != "" && err == nil && parsed.Query().Get("agentid") == "" {
+			v.addf("op_authorize_url %q must include the agentid of the WeCom app as a query parameter, e.g. agentid=1000002", cfg.OPAuthURL)
+		}
+	default:
+		v.addf("unsupported op_dialect %q, use dingtalk or wecom", cfg.OPDialect)
+	}
+	v.validateRequestTemplate("op_token_request", cfg.OPTokenRequest)
+	v.validateRequestTemplate("op_authorize_request", cfg.OPAuthorizeRequest)
+
+	if cfg.IDTokenLifetime <= 0 {
+		v.addf("id_token_lifetime must be a positive number of seconds, got %d", cfg.IDTokenLifetime)
+	}
+	if cfg.NonceCacheTTL <= 0 {
+		v.addf("nonce_cache_ttl must be a positive number of seconds, got %d", cfg.NonceCacheTTL)
+	}
+	v.nonNegative("refresh_token_ttl", cfg.RefreshTokenTTL)
+	v.nonNegative("auth_code_ttl", cfg.AuthCodeTTL)
+
+	if cfg.SigningAlg != "" && !contains(signingAlgs, cfg.SigningAlg) {
+		v.addf("unsupported id_token_signing_alg %q, use one of %s", cfg.SigningAlg, strings.Join(signingAlgs, ", "))
+	}
+	switch cfg.SigningKeyStorage {
+	case "", "file":
+		if len(cfg.SigningKeys) == 0 && cfg.SigningKeysDir == "" && cfg.PrivateKeyPath == "" {
+			v.addf("private_key_path is required unless signing_keys, signing_keys_dir or signing_key_storage: store is used")
+		}
+	case "store", "redis":
+	default:
+		v.addf("unsupported signing_key_storage %q, use file or store", cfg.SigningKeyStorage)
+	}
+	for i, key := range cfg.SigningKeys {
+		if key.PrivateKeyPath == "" {
+			v.addf("signing_keys[%d]: private_key_path is required", i)
+		}
+		for _, field := range [][2]string{{"not_before", key.NotBefore}, {"not_after", key.NotAfter}} {
+			if _, err := time.Parse(time.RFC3339, field[1]); field[1] != "" && err != nil {
+				v.addf("signing_keys[%d]: %s %q is not an RFC 3339 time", i, field[0], field[1])
+			}
+		}
+	}
+}
+
+// validateRequestTemplate 检查 OP 请求模板，授权请求是浏览器跳转，只能调整查询参数
+func (v *validator) validateRequestTemplate(key string, tmpl model.OPRequestConfig) {
+	if key == "op_authorize_request" {
+		if tmpl.Encoding != "" || len(tmpl.Headers) > 0 {
+			v.addf("%s only supports params and extra_params, the authorization request is a browser redirect", key)
+		}
+	} else {
+		switch tmpl.Encoding {
+		case "", "form", "json":
+		default:
+			v.addf("unsupported %s.encoding %q, use form or json", key, tmpl.Encoding)
+		}
+	}
+	for _, param := range tmpl.ExtraParams {
+		if name, _, ok := strings.Cut(param, "="); !ok || name == "" {
+			v.addf("%s.extra_params entry %q must have the form name=value", key, param)
+		}
+	}
+}
+
+// validateAttributeMapping 检查属性映射：必须恰好有一个 OP 属性映射到 sub，每个 claim 只能由一个属性映射
+func (v *validator) validateAttributeMapping(cfg *model.Config) {
+	sources := make(map[string][]string)
+	var claims []string
+	for opAttr, claim := range cfg.AttrMapping {
+		if opAttr == "" || claim == "" {
+			v.addf("user_attribute_mapping: empty attribute or claim in %q: %q", opAttr, claim)
+			continue
+		}
+		if len(sources[claim]) == 0 {
+			claims = append(claims, claim)
+		}
+		sources[claim] = append(sources[claim], opAttr)
+	}
+	if len(sources["sub"]) == 0 {
+		v.addf("user_attribute_mapping must map exactly one OP attribute to sub, e.g. \"data::open_id\": \"sub\"")
+	}
+	sort.Strings(claims)
+	for _, claim := range claims {
+		if attrs := sources[claim]; len(attrs) > 1 {
+			sort.Strings(attrs)
+			v.addf("user_attribute_mapping maps %s to claim %s, keep only one", strings.Join(attrs, " and "), claim)
+		}
+	}
+}
+
+// validateClients 检查客户端注册表，每个 client 必须有唯一的 client_id 和至少一个 redirect_uri
+// 配置了 op_client_id 时 OP 只认识桥接服务，必须启用桥接回调并通过注册表限定 RP
+// 动态注册的客户端 OP 并不认识，因此启用注册时同样要求 op_client_id 和桥接回调
+func (v *validator) validateClients(cfg *model.Config) {
+	if cfg.OPClientID != "" {
+		if !cfg.BridgeCallback {
+			v.addf("op_client_id requires bridge_callback")
+		}
+		if len(cfg.Clients) == 0 && !cfg.Registration.Enabled {
+			v.addf("op_client_id requires a clients registry or dynamic registration")
+		}
+	}
+	if cfg.Registration.Enabled {
+		if cfg.Registration.InitialAccessToken == "" {
+			v.addf("registration requires an initial_access_token")
+		}
+		if cfg.OPClientID == "" {
+			v.addf("registration requires op_client_id")
+		}
+		if !cfg.BridgeCallback {
+			v.addf("registration requires bridge_callback")
+		}
+	}
+	switch cfg.OPTokenAuthMethod {
+	case "", "client_secret_post", "client_secret_basic":
+	default:
+		v.addf("unsupported op_token_auth_method %q, use client_secret_post or client_secret_basic", cfg.OPTokenAuthMethod)
+	}
+
+	ids := make(map[string]bool)
+	for i, client := range cfg.Clients {
+		if client.ClientID == "" {
+			v.addf("clients[%d]: client_id is required", i)
+			continue
+		}
+		if ids[client.ClientID] {
+			v.addf("duplicate client_id: %s", client.ClientID)
+		}
+		ids[client.ClientID] = true
+		if len(client.RedirectURIs) == 0 {
+			v.addf("client %s: at least one redirect_uri is required", client.ClientID)
+		}
+		for _, redirectURI := range client.RedirectURIs {
+			if parsed, err := url.Parse(redirectURI); err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+				v.addf("client %s: redirect_uri %q must be an absolute URI without fragment", client.ClientID, redirectURI)
+			}
+		}
+		v.nonNegative("client "+client.ClientID+": id_token_lifetime", client.IDTokenLifetime)
+		v.nonNegative("client "+client.ClientID+": refresh_token_ttl", client.RefreshTokenTTL)
+		switch client.TokenEndpointAuthMethod {
+		case "", "none", "client_secret_basic", "client_secret_post", "client_secret_jwt":
+		case "private_key_jwt":
+			if client.JWKS == nil && client.JWKSURI == "" {
+				v.addf("client %s: private_key_jwt requires jwks or jwks_uri", client.ClientID)
+			}
+		default:
+			v.addf("client %s: unsupported token_endpoint_auth_method: %s", client.ClientID, client.TokenEndpointAuthMethod)
+		}
+		if parsed, err := url.Parse(client.JWKSURI); client.JWKSURI != "" && (err != nil || parsed.Scheme != "https" || parsed.Host == "") {
+			v.addf("client %s: jwks_uri %q must be an absolute https URL", client.ClientID, client.JWKSURI)
+		}
+	}
+}
+
+// validateStore 检查存储配置
+func (v *validator) validateStore(cfg *model.Config) {
+	switch cfg.Store {
+	case "", "redis", "memory":
+	case "sql":
+		switch cfg.SQL.Driver {
+		case "sqlite", "postgres":
+		default:
+			v.addf("unsupported sql.driver %q, use sqlite or postgres", cfg.SQL.Driver)
+		}
+		if cfg.SQL.DSN == "" {
+			v.addf("sql.dsn is required for store: sql")
+		}
+	default:
+		v.addf("unsupported store %q, use redis, sql or memory", cfg.Store)
+	}
+	switch cfg.Redis.FailurePolicy {
+	case "", "fail", "fallback", "degrade":
+	default:
+		v.addf("unsupported redis.failure_policy %q, use fail, fallback or degrade", cfg.Redis.FailurePolicy)
+	}
+	v.nonNegative("redis.reconnect_interval", cfg.Redis.ReconnectInterval)
+	v.nonNegative("redis.max_reconnect_interval", cfg.Redis.MaxReconnectInterval)
+	v.nonNegative("sql.cleanup_interval", cfg.SQL.CleanupInterval)
+	v.nonNegative("memory.cleanup_interval", cfg.Memory.CleanupInterval)
+}
+
+func (v *validator) requireURL(key, value, description string) {
+	if value == "" {
+		v.addf("%s is required: set it to %s", key, description)
+		return
+	}
+	v.checkURL(key, value)
+}
+
+// checkURL 检查 http(s) 绝对地址，地址中可以包含 {client_id} 之类的模板占位符
+func (v *validator) checkURL(key, value string) {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "https" && parsed.Scheme != "http" || parsed.Host == "" {
+		v.addf("%s %q must be an absolute http(s) URL", key, value)
+	}
+}
+
+func (v *validator) nonNegative(key string, value int) {
+	if value < 0 {
+		v.addf("%s must not be negative, got %d", key, value)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
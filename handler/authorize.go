@@ -1,9 +1,10 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
-	"oidc-bridge/config"
+	"oidc-bridge/model"
 	"oidc-bridge/service"
 	"oidc-bridge/utils"
 	"strings"
@@ -19,59 +20,121 @@ func HandleAuthorize(c *gin.Context) {
 	scope := c.Query("scope")
 	state := c.Query("state")
 	nonce := c.Query("nonce")
+	codeChallenge := c.Query("code_challenge")
+	codeChallengeMethod := c.Query("code_challenge_method")
 
 	utils.DebugLogger.Printf("Handling authorize request for client: %s", clientID)
 
+	// client_id 或 redirect_uri 无效时不能重定向回 RP，直接返回错误
+	cfg := providerConfig(c)
+	client, err := service.LookupClient(cfg, clientID)
+	if errors.Is(err, service.ErrUnknownClient) {
+		utils.ErrorLogger.Printf("Unknown client: %s", clientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "unknown client_id"})
+		return
+	} else if err != nil {
+		utils.ErrorLogger.Printf("Failed to load client: %s, error: %v", clientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to load client"})
+		return
+	}
+	if !service.RedirectURIAllowed(client, redirectURI) {
+		utils.ErrorLogger.Printf("Unregistered redirect_uri: %s for client: %s", redirectURI, clientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "redirect_uri is not registered for this client"})
+		return
+	}
+
 	if responseType != "code" {
 		utils.ErrorLogger.Printf("Unsupported response type: %s for client: %s", responseType, clientID)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_response_type"})
 		return
 	}
 
-	// 2. 处理 scope 映射
-	hasOpenID := false
-	var mappedScopes []string
-	for _, s := range strings.Split(scope, " ") {
-		s = strings.TrimSpace(s)
-		if s == "openid" {
-			hasOpenID = true
-			continue
-		}
-		if mapped, ok := config.AppConfig.ScopeMapping[s]; ok {
-			if mapped == "" {
-				continue
-			}
-			mappedScopes = append(mappedScopes, mapped)
-		} else {
-			mappedScopes = append(mappedScopes, s)
+	if codeChallenge != "" {
+		method, err := service.NormalizeCodeChallenge(codeChallenge, codeChallengeMethod)
+		if err != nil {
+			utils.ErrorLogger.Printf("Invalid PKCE parameters for client: %s, error: %v", clientID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+			return
 		}
+		codeChallengeMethod = method
+	} else if codeChallengeMethod != "" {
+		utils.ErrorLogger.Printf("code_challenge_method without code_challenge for client: %s", clientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "code_challenge is required when code_challenge_method is present"})
+		return
 	}
 
-	// 3. 缓存 nonce (如果提供)
-	if hasOpenID && nonce != "" {
-		// 存储 nonce 到 Redis
-		err := service.SetNonce(clientID, redirectURI, nonce)
-		if err != nil {
-			utils.ErrorLogger.Printf("Failed to cache nonce for client: %s, error: %v", clientID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to cache nonce"})
+	// 2. 检查 client 是否允许使用请求的 scope，不允许时重定向回 RP
+	if disallowed := service.DisallowedScopes(client, scope); len(disallowed) > 0 {
+		utils.ErrorLogger.Printf("Scopes %v not allowed for client: %s", disallowed, clientID)
+		redirectAuthorizeError(c, clientID, redirectURI, state, "invalid_scope", "scope not allowed for this client: "+strings.Join(disallowed, " "))
+		return
+	}
+
+	// 桥接服务以自己的凭据访问 OP 时，公开客户端必须使用 PKCE 保护授权码
+	if service.TranslatesClientCredentials(cfg) && service.IsPublicClient(client) && codeChallenge == "" {
+		utils.ErrorLogger.Printf("Public client: %s did not send code_challenge", clientID)
+		redirectAuthorizeError(c, clientID, redirectURI, state, "invalid_request", "code_challenge is required for public clients")
+		return
+	}
+	if cfg.RequirePKCE && codeChallenge == "" {
+		utils.ErrorLogger.Printf("Client: %s did not send code_challenge", clientID)
+		redirectAuthorizeError(c, clientID, redirectURI, state, "invalid_request", "code_challenge is required")
+		return
+	}
+
+	// 3. 处理 scope 映射
+	mappedScopes, hasOpenID := service.MapScopes(cfg, scope)
+
+	// 4. 保存授权上下文，兑换授权码时取出并绑定到授权码上，只能使用一次
+	txn := &model.AuthTransaction{
+		ClientID:    clientID,
+		RedirectURI: redirectURI,
+		Scope:       scope,
+		State:       state,
+		Provider:    cfg.Name,
+	}
+	if hasOpenID {
+		txn.Nonce = nonce
+	}
+	// OP 不支持 PKCE 时，由桥接服务保存 code_challenge 并在 /token 时自行校验
+	if codeChallenge != "" && !cfg.OPSupportsPKCE {
+		txn.CodeChallenge = codeChallenge
+		txn.CodeChallengeMethod = codeChallengeMethod
+	}
+
+	opRedirectURI := redirectURI
+	opState := state
+	if cfg.BridgeCallback {
+		// 桥接回调模式：OP 回调到桥接服务的 /callback，授权上下文以随机事务 ID 保存，并发登录互不覆盖
+		txnID, ok := saveAuthTransaction(c, txn)
+		if !ok {
 			return
 		}
-		utils.DebugLogger.Printf("Nonce cached for client: %s", clientID)
+		opRedirectURI = txn.CallbackURL
+		opState = txnID
+	} else if err := savePendingTransaction(cfg, codeChallenge, codeChallengeMethod, txn); err != nil {
+		utils.ErrorLogger.Printf("Failed to save authorization request for client: %s, error: %v", clientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to save authorization request"})
+		return
 	}
 
-	// 4. 构建重定向 URL
-	opAuthURL := config.AppConfig.OPAuthURL
+	// 5. 构建重定向 URL
+	opAuthURL := cfg.OPAuthURL
 	queryParams := url.Values{}
 	queryParams.Add("response_type", "code")
-	queryParams.Add("client_id", clientID)
-	queryParams.Add("redirect_uri", redirectURI)
+	queryParams.Add("client_id", service.OPClientID(cfg, clientID))
+	queryParams.Add("redirect_uri", opRedirectURI)
 	queryParams.Add("scope", strings.Join(mappedScopes, " "))
-	if state != "" {
-		queryParams.Add("state", state)
+	if opState != "" {
+		queryParams.Add("state", opState)
 	}
 	if hasOpenID && nonce != "" {
 		queryParams.Add("nonce", nonce)
 	}
+	if codeChallenge != "" && cfg.OPSupportsPKCE {
+		queryParams.Add("code_challenge", codeChallenge)
+		queryParams.Add("code_challenge_method", codeChallengeMethod)
+	}
 
 	// 构建完整 URL
 	redirectURL, err := url.Parse(opAuthURL)
@@ -80,9 +143,53 @@ func HandleAuthorize(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to parse OP auth URL"})
 		return
 	}
-	redirectURL.RawQuery = queryParams.Encode()
+	// 保留 op_authorize_url 中固定的参数（如钉钉的 prompt、企业微信的 agentid），并按 op_authorize_request 模板调整参数
+	redirectURL.RawQuery = service.BuildOPParams(cfg.OPAuthorizeRequest, queryParams, redirectURL.Query()).Encode()
 
 	// 重定向到 OP
 	utils.DebugLogger.Printf("Redirecting client: %s to OP", clientID)
 	c.Redirect(http.StatusFound, redirectURL.String())
 }
+
+// redirectAuthorizeError 以授权错误重定向回 RP，带回 RP 的原始 state
+func redirectAuthorizeError(c *gin.Context, clientID, redirectURI, state, errorCode, description string) {
+	params := url.Values{}
+	params.Set("error", errorCode)
+	params.Set("error_description", description)
+	if state != "" {
+		params.Set("state", state)
+	}
+	redirectToClient(c, clientID, redirectURI, params)
+}
+
+// savePendingTransaction 在透传模式下保存授权上下文，使用 PKCE 时绑定到本次授权的 code_challenge，
+// 否则按 client_id 和 redirect_uri 保存，同一 RP 的并发登录会互相覆盖
+func savePendingTransaction(cfg *model.Config, codeChallenge, codeChallengeMethod string, txn *model.AuthTransaction) error {
+	if codeChallenge == "" {
+		return service.SaveUnboundTransaction(cfg, txn)
+	}
+	return service.SavePendingTransaction(cfg, codeChallenge, codeChallengeMethod, txn)
+}
+
+// saveAuthTransaction 在桥接回调模式下保存授权事务，返回交给 OP 作为 state 的事务 ID
+func saveAuthTransaction(c *gin.Context, txn *model.AuthTransaction) (string, bool) {
+	if txn.RedirectURI == "" {
+		utils.ErrorLogger.Printf("Missing redirect_uri for client: %s", txn.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "redirect_uri is required"})
+		return "", false
+	}
+	txn.CallbackURL = resolveCallbackURL(c)
+
+	txnID, err := service.NewRandomToken()
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to generate transaction ID for client: %s, error: %v", txn.ClientID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to generate transaction"})
+		return "", false
+	}
+	if err := service.SaveTransaction(providerConfig(c), txnID, txn); err != nil {
+		utils.ErrorLogger.Printf("Failed to save transaction for client: %s, error: %v", txn.ClientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to save transaction"})
+		return "", false
+	}
+	return txnID, true
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"oidc-bridge/service"
+	"oidc-bridge/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleCallback 处理桥接回调模式下 OP 的授权回调，签发桥接授权码后重定向回 RP
+func HandleCallback(c *gin.Context) {
+	// 1. 根据 state 取出当前提供方的授权事务，事务只能使用一次，其他提供方的事务不会被消费
+	txn, err := service.TakeTransaction(providerConfig(c).Name, c.Query("state"))
+	if err != nil {
+		if errors.Is(err, service.ErrTransactionNotFound) {
+			utils.ErrorLogger.Printf("Authorization transaction not found or expired")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "unknown or expired state"})
+			return
+		}
+		utils.ErrorLogger.Printf("Failed to load authorization transaction: %v", err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to load transaction"})
+		return
+	}
+
+	utils.DebugLogger.Printf("Handling OP callback for client: %s", txn.ClientID)
+
+	// 2. OP 返回错误时透传给 RP
+	params := url.Values{}
+	if opError := c.Query("error"); opError != "" {
+		utils.ErrorLogger.Printf("OP returned authorization error: %s for client: %s", opError, txn.ClientID)
+		params.Set("error", opError)
+		if description := c.Query("error_description"); description != "" {
+			params.Set("error_description", description)
+		}
+	} else if opCode := c.Query("code"); opCode == "" {
+		utils.ErrorLogger.Printf("OP callback without code for client: %s", txn.ClientID)
+		params.Set("error", "server_error")
+		params.Set("error_description", "authorization server did not return a code")
+	} else {
+		// 3. 签发桥接授权码，绑定 OP 授权码和授权上下文
+		code, err := service.NewRandomToken()
+		if err == nil {
+			txn.OPCode = opCode
+			err = service.SaveAuthCode(providerConfig(c), code, txn)
+		}
+		if err != nil {
+			utils.ErrorLogger.Printf("Failed to issue authorization code for client: %s, error: %v", txn.ClientID, err)
+			params.Set("error", "server_error")
+			params.Set("error_description", "failed to issue authorization code")
+		} else {
+			params.Set("code", code)
+		}
+	}
+	if txn.State != "" {
+		params.Set("state", txn.State)
+	}
+
+	// 4. 重定向回 RP
+	redirectToClient(c, txn.ClientID, txn.RedirectURI, params)
+}
+
+// redirectToClient 携带授权结果重定向回 RP，保留 redirect_uri 中原有的查询参数
+func redirectToClient(c *gin.Context, clientID, redirectURI string, params url.Values) {
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to parse redirect URI for client: %s, error: %v", clientID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "invalid redirect_uri"})
+		return
+	}
+	query := redirectURL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	redirectURL.RawQuery = query.Encode()
+
+	utils.DebugLogger.Printf("Redirecting back to client: %s", clientID)
+	c.Redirect(http.StatusFound, redirectURL.String())
+}
+
+// resolveCallbackURL 获取桥接服务自身的回调地址，未配置 callback_url 时使用 Issuer + /callback
+func resolveCallbackURL(c *gin.Context) string {
+	if cfg := providerConfig(c); cfg.CallbackURL != "" {
+		return cfg.CallbackURL
+	}
+	return resolveIssuer(c) + "/callback"
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"oidc-bridge/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authenticateClient 提取并校验 token 请求中的客户端凭据，返回注册表中的 client（未配置注册表时为 nil），失败时直接写入错误响应
+// 支持 client_secret_basic、client_secret_post、client_secret_jwt 和 private_key_jwt，一个请求只能使用一种方式
+func authenticateClient(c *gin.Context, req *model.TokenRequest) (*model.ClientConfig, bool) {
+	// 1. 提取凭据
+	creds, ok := clientCredentials(c, req)
+	if !ok {
+		return nil, false
+	}
+
+	// 2. 查找 client
+	cfg := providerConfig(c)
+	client, err := service.LookupClient(cfg, req.ClientID)
+	if errors.Is(err, service.ErrUnknownClient) {
+		utils.ErrorLogger.Printf("Unknown client at token endpoint: %s", req.ClientID)
+		respondInvalidClient(c, creds, "unknown client_id")
+		return nil, false
+	} else if err != nil {
+		utils.ErrorLogger.Printf("Failed to load client: %s, error: %v", req.ClientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to load client"})
+		return nil, false
+	}
+
+	// 3. 校验凭据，客户端断言的 aud 可以是 token 端点地址或 Issuer
+	issuer := resolveIssuer(c)
+	if err := service.AuthenticateClient(client, creds, []string{issuer + "/token", issuer}); err != nil {
+		if !errors.Is(err, service.ErrClientAuthentication) {
+			utils.ErrorLogger.Printf("Failed to authenticate client: %s, error: %v", req.ClientID, err)
+			c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to authenticate client"})
+			return nil, false
+		}
+		utils.ErrorLogger.Printf("Client authentication failed for client: %s, error: %v", req.ClientID, err)
+		respondInvalidClient(c, creds, "client authentication failed")
+		return nil, false
+	}
+
+	// 使用客户端断言认证时 RP 没有提交 client_secret，向 OP 兑换时使用注册表中的密钥
+	if creds.Assertion != "" && client != nil {
+		req.ClientSecret = client.ClientSecret
+	}
+	return client, true
+}
+
+// clientCredentials 从 Authorization 头和表单中提取客户端凭据，client_id 缺失时从 Basic 凭据或客户端断言中获取
+func clientCredentials(c *gin.Context, req *model.TokenRequest) (service.ClientCredentials, bool) {
+	basicID, basicSecret, hasBasic := c.Request.BasicAuth()
+	hasAssertion := req.ClientAssertion != "" || req.ClientAssertionType != ""
+
+	used := 0
+	for _, present := range []bool{hasBasic, req.ClientSecret != "", hasAssertion} {
+		if present {
+			used++
+		}
+	}
+	if used > 1 {
+		utils.ErrorLogger.Printf("Multiple client authentication methods used by client: %s", req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "only one client authentication method may be used"})
+		return service.ClientCredentials{}, false
+	}
+
+	creds := service.ClientCredentials{Method: service.AuthMethodNone}
+	switch {
+	case hasBasic:
+		// client_secret_basic 的用户名和密码经过表单编码（RFC 6749 2.3.1）
+		clientID, errID := url.QueryUnescape(basicID)
+		clientSecret, errSecret := url.QueryUnescape(basicSecret)
+		creds.Method = service.AuthMethodClientSecretBasic
+		if errID != nil || errSecret != nil {
+			respondInvalidClient(c, creds, "malformed client credentials")
+			return service.ClientCredentials{}, false
+		}
+		if req.ClientID != "" && req.ClientID != clientID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "client_id does not match the Authorization header"})
+			return service.ClientCredentials{}, false
+		}
+		req.ClientID, req.ClientSecret = clientID, clientSecret
+	case hasAssertion:
+		if req.ClientAssertionType != service.ClientAssertionTypeJWTBearer || req.ClientAssertion == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "unsupported client_assertion_type"})
+			return service.ClientCredentials{}, false
+		}
+		if req.ClientID == "" {
+			req.ClientID = service.AssertionClientID(req.ClientAssertion)
+		}
+		creds.Assertion = req.ClientAssertion
+	case req.ClientSecret != "":
+		creds.Method = service.AuthMethodClientSecretPost
+	}
+	creds.ClientID, creds.ClientSecret = req.ClientID, req.ClientSecret
+	return creds, true
+}
+
+// respondInvalidClient 返回 invalid_client，client 使用 Authorization 头认证时按 RFC 6749 5.2 附带 WWW-Authenticate
+func respondInvalidClient(c *gin.Context, creds service.ClientCredentials, description string) {
+	if creds.Method == service.AuthMethodClientSecretBasic {
+		c.Header("WWW-Authenticate", `Basic realm="token"`)
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client", "error_description": description})
+}
@@ -2,15 +2,16 @@ package handler
 
 import (
 	"net/http"
-	"oidc-bridge/config"
 	"oidc-bridge/model"
+	"oidc-bridge/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 func HandleDiscovery(c *gin.Context) {
 	// 如果配置中没有提供 Issuer，则从请求的 URL 中获取
-	issuer := config.AppConfig.Issuer
+	cfg := providerConfig(c)
+	issuer := cfg.Issuer
 	if issuer == "" {
 		// 获取请求的协议
 		scheme := "http"
@@ -27,18 +28,24 @@ func HandleDiscovery(c *gin.Context) {
 			host = "example.com"
 		}
 
-		issuer = scheme + "://" + host
+		issuer = scheme + "://" + host + cfg.PathPrefix
 	}
 
 	discovery := model.Discovery{
-		Issuer:                           issuer,
-		AuthorizationEndpoint:            issuer + "/authorize",
-		TokenEndpoint:                    issuer + "/token",
-		UserInfoEndpoint:                 issuer + "/userinfo",
-		JwksURI:                          issuer + "/.well-known/jwks.json",
-		ScopesSupported:                  []string{"openid", "profile", "email"},
-		ResponseTypesSupported:           []string{"code"},
-		IDTokenSigningAlgValuesSupported: []string{config.AppConfig.SigningAlg},
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{"openid", "profile", "email"},
+		ResponseTypesSupported:            []string{"code"},
+		IDTokenSigningAlgValuesSupported:  []string{service.SigningAlg(cfg)},
+		CodeChallengeMethodsSupported:     []string{"S256", "plain"},
+		TokenEndpointAuthMethodsSupported: service.TokenEndpointAuthMethods,
+		TokenEndpointAuthSigningAlgValuesSupported: service.ClientAssertionAlgs,
+	}
+	if service.RegistrationEnabled(cfg) {
+		discovery.RegistrationEndpoint = issuer + "/register"
 	}
 	c.JSON(http.StatusOK, discovery)
 }
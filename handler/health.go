@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"oidc-bridge/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleHealth 返回服务和存储的健康状态
+// 存储正常时为 ok；临时使用本地内存时为 degraded；存储不可用时为 unavailable 并返回 503
+func HandleHealth(c *gin.Context) {
+	store := service.StoreHealth()
+
+	status, code := "ok", http.StatusOK
+	switch {
+	case store.Mode == service.StoreModeFallback:
+		status = "degraded"
+	case !store.Healthy:
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "store": store})
+}
+
+// storeErrorStatus 存储不可用时返回 503，使客户端可以稍后重试
+func storeErrorStatus(err error) int {
+	if errors.Is(err, service.ErrStoreUnavailable) || errors.Is(err, service.ErrStoreFull) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
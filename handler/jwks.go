@@ -1,8 +1,6 @@
 package handler
 
 import (
-	"crypto/x509"
-	"encoding/base64"
 	"fmt"
 	"net/http"
 	"oidc-bridge/model"
@@ -12,33 +10,25 @@ import (
 )
 
 func HandleJWKS(c *gin.Context) {
-	// 1. 加载公钥
-	publicKey, err := service.LoadPublicKey()
+	// 1. 加载需要发布的公钥（轮换期间包含新旧多个密钥）
+	cfg := providerConfig(c)
+	publicKeys, err := service.PublishedPublicKeys(cfg)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": fmt.Sprintf("failed to load public key: %v", err)})
 		return
 	}
 
-	// 2. 构建 JWKS
-	// 将公钥转换为 DER 格式
-	pubKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": fmt.Sprintf("failed to marshal public key: %v", err)})
-		return
-	}
-
-	// 构建 JWK
-	jwk := model.JWK{
-		KTY: "RSA",
-		Use: "sig",
-		Kid: "1",
-		N:   base64.RawURLEncoding.EncodeToString(pubKeyBytes),
-		E:   "AQAB", // 65537 的 Base64 URL 编码
-	}
-
-	// 构建 JWKS
+	// 2. 构建 JWKS，kid 与 ID Token 头部中的 kid 一致
 	jwks := model.JWKS{
-		Keys: []model.JWK{jwk},
+		Keys: make([]model.JWK, 0, len(publicKeys)),
+	}
+	for _, publicKey := range publicKeys {
+		jwk, err := service.PublicJWK(publicKey, service.SigningAlg(cfg))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": fmt.Sprintf("failed to build JWK: %v", err)})
+			return
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
 	}
 
 	c.JSON(http.StatusOK, jwks)
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// providerContextKey gin.Context 中保存当前提供方配置的键
+const providerContextKey = "oidc-bridge.provider"
+
+// RegisterProviders 注册所有提供方的 OIDC 端点
+// 根路径按 Host 选择提供方，配置了 path_prefix 的提供方在各自前缀下提供完整端点
+// 路由在启动时按当前配置注册，每个请求处理时再从最新的配置中取出对应的提供方
+func RegisterProviders(router gin.IRouter) {
+	registerOIDCRoutes(router.Group("/", ProviderByHost()))
+	for _, provider := range config.Current().Providers {
+		if provider.PathPrefix != "" {
+			registerOIDCRoutes(router.Group(provider.PathPrefix, UseProvider(provider.Name)))
+		}
+	}
+}
+
+// registerOIDCRoutes 注册一个提供方的 OIDC 端点
+func registerOIDCRoutes(routes gin.IRoutes) {
+	routes.GET("/.well-known/openid-configuration", HandleDiscovery)
+	routes.GET("/authorize", HandleAuthorize)
+	routes.GET("/callback", HandleCallback)
+	routes.POST("/token", HandleToken)
+	routes.GET("/userinfo", HandleUserInfo)
+	routes.GET("/.well-known/jwks.json", HandleJWKS)
+	routes.POST("/register", HandleRegister)
+	routes.GET("/register/:client_id", HandleGetClientRegistration)
+	routes.PUT("/register/:client_id", HandleUpdateClientRegistration)
+	routes.DELETE("/register/:client_id", HandleDeleteClientRegistration)
+}
+
+// UseProvider 将路径前缀下的请求绑定到指定名称的提供方，提供方同时配置了 host 时只接受该主机名的请求
+func UseProvider(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cfg *model.Config
+		for _, provider := range config.Current().Providers {
+			if provider.Name == name {
+				cfg = provider
+				break
+			}
+		}
+		if cfg == nil || (cfg.Host != "" && !hostMatches(c.Request.Host, cfg.Host)) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "unknown provider"})
+			return
+		}
+		c.Set(providerContextKey, cfg)
+		c.Next()
+	}
+}
+
+// ProviderByHost 根据 Host 为根路径下的请求选择提供方，没有匹配时使用顶层配置
+// 选中的配置保存在请求上下文中，重新加载配置不影响正在处理的请求
+func ProviderByHost() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		current := config.Current()
+		for _, cfg := range current.Providers {
+			if cfg.Host != "" && cfg.PathPrefix == "" && hostMatches(c.Request.Host, cfg.Host) {
+				c.Set(providerContextKey, cfg)
+				c.Next()
+				return
+			}
+		}
+
+		// 配置了 providers 且顶层配置没有 OP 地址时，根路径不对外提供服务
+		if len(current.Providers) > 0 && current.OPAuthURL == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "unknown provider"})
+			return
+		}
+		c.Set(providerContextKey, current)
+		c.Next()
+	}
+}
+
+// providerConfig 返回处理当前请求的提供方配置，未经过提供方中间件时（如单元测试）使用顶层配置
+func providerConfig(c *gin.Context) *model.Config {
+	if value, ok := c.Get(providerContextKey); ok {
+		return value.(*model.Config)
+	}
+	return config.Current()
+}
+
+// hostMatches 比较请求的 Host 与配置的主机名，配置中未包含端口时忽略请求中的端口
+func hostMatches(requestHost, host string) bool {
+	if strings.EqualFold(requestHost, host) {
+		return true
+	}
+	if hostname, _, err := net.SplitHostPort(requestHost); err == nil {
+		return strings.EqualFold(hostname, host)
+	}
+	return false
+}
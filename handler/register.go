@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"oidc-bridge/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleRegister 处理动态客户端注册请求（RFC 7591），请求必须携带初始访问令牌
+func HandleRegister(c *gin.Context) {
+	cfg := providerConfig(c)
+	if !service.RegistrationEnabled(cfg) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "client registration is disabled"})
+		return
+	}
+
+	// 1. 校验初始访问令牌
+	if !service.VerifyInitialAccessToken(cfg, bearerToken(c)) {
+		utils.ErrorLogger.Printf("Client registration with invalid initial access token")
+		respondInvalidToken(c)
+		return
+	}
+
+	// 2. 解析客户端元数据
+	var metadata model.ClientRegistration
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_client_metadata", "error_description": err.Error()})
+		return
+	}
+
+	// 3. 注册客户端并返回凭据和注册访问令牌
+	registration, err := service.RegisterClient(cfg, &metadata)
+	if err != nil {
+		respondRegistrationError(c, err)
+		return
+	}
+	registration.RegistrationClientURI = registrationClientURI(c, registration.ClientID)
+	c.JSON(http.StatusCreated, registration)
+}
+
+// HandleGetClientRegistration 读取动态注册客户端的元数据（RFC 7592）
+func HandleGetClientRegistration(c *gin.Context) {
+	cfg := providerConfig(c)
+	if !service.RegistrationEnabled(cfg) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "client registration is disabled"})
+		return
+	}
+
+	registration, err := service.GetRegisteredClient(cfg, c.Param("client_id"), bearerToken(c))
+	if err != nil {
+		respondRegistrationError(c, err)
+		return
+	}
+	registration.RegistrationClientURI = registrationClientURI(c, registration.ClientID)
+	c.JSON(http.StatusOK, registration)
+}
+
+// HandleUpdateClientRegistration 整体替换动态注册客户端的元数据（RFC 7592）
+func HandleUpdateClientRegistration(c *gin.Context) {
+	cfg := providerConfig(c)
+	if !service.RegistrationEnabled(cfg) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "client registration is disabled"})
+		return
+	}
+
+	var metadata model.ClientRegistration
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_client_metadata", "error_description": err.Error()})
+		return
+	}
+
+	registration, err := service.UpdateRegisteredClient(cfg, c.Param("client_id"), bearerToken(c), &metadata)
+	if err != nil {
+		respondRegistrationError(c, err)
+		return
+	}
+	registration.RegistrationClientURI = registrationClientURI(c, registration.ClientID)
+	c.JSON(http.StatusOK, registration)
+}
+
+// HandleDeleteClientRegistration 注销动态注册的客户端（RFC 7592）
+func HandleDeleteClientRegistration(c *gin.Context) {
+	cfg := providerConfig(c)
+	if !service.RegistrationEnabled(cfg) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "client registration is disabled"})
+		return
+	}
+
+	if err := service.DeleteRegisteredClient(cfg, c.Param("client_id"), bearerToken(c)); err != nil {
+		respondRegistrationError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// respondRegistrationError 将注册服务的错误转换为 RFC 7591/7592 的错误响应
+func respondRegistrationError(c *gin.Context, err error) {
+	var registrationErr *service.RegistrationError
+	switch {
+	case errors.As(err, &registrationErr):
+		utils.ErrorLogger.Printf("Invalid client metadata: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": registrationErr.Code, "error_description": registrationErr.Description})
+	case errors.Is(err, service.ErrInvalidRegistrationToken):
+		utils.ErrorLogger.Printf("Client registration request for client: %s with invalid registration access token", c.Param("client_id"))
+		respondInvalidToken(c)
+	default:
+		utils.ErrorLogger.Printf("Failed to process client registration: %v", err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to process client registration"})
+	}
+}
+
+// respondInvalidToken 返回 RFC 6750 的 invalid_token 错误
+func respondInvalidToken(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token", "error_description": "missing or invalid access token"})
+}
+
+// registrationClientURI 返回客户端配置端点地址
+func registrationClientURI(c *gin.Context, clientID string) string {
+	return resolveIssuer(c) + "/register/" + clientID
+}
+
+// bearerToken 提取 Authorization 头中的 Bearer 令牌，格式不正确时返回空字符串
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return header[7:]
+	}
+	return ""
+}
@@ -1,8 +1,8 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
-	"oidc-bridge/config"
 	"oidc-bridge/model"
 	"oidc-bridge/service"
 	"oidc-bridge/utils"
@@ -20,63 +20,331 @@ func HandleToken(c *gin.Context) {
 		return
 	}
 
-	// 获取 scope 参数
-	scope := c.PostForm("scope")
+	// 2. 认证 client，配置了客户端注册表时只接受已注册的 client 及其允许的 redirect_uri 和 scope
+	client, ok := authenticateClient(c, &req)
+	if !ok {
+		return
+	}
+	if req.GrantType == "authorization_code" && !service.RedirectURIAllowed(client, req.RedirectURI) {
+		utils.ErrorLogger.Printf("Unregistered redirect_uri: %s for client: %s", req.RedirectURI, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "redirect_uri is not registered for this client"})
+		return
+	}
+	if disallowed := service.DisallowedScopes(client, req.Scope); len(disallowed) > 0 {
+		utils.ErrorLogger.Printf("Scopes %v not allowed for client: %s", disallowed, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope", "error_description": "scope not allowed for this client: " + strings.Join(disallowed, " ")})
+		return
+	}
 
-	// 2. 向 OP 代理请求
-	opResp, err := service.ProxyToOPTokenEndpoint(req)
+	// 3. 根据 grant_type 分别处理
+	switch req.GrantType {
+	case "authorization_code":
+		handleAuthorizationCodeGrant(c, client, req)
+	case "refresh_token":
+		handleRefreshTokenGrant(c, req)
+	default:
+		utils.ErrorLogger.Printf("Unsupported grant type: %s for client: %s", req.GrantType, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+	}
+}
+
+func handleAuthorizationCodeGrant(c *gin.Context, client *model.ClientConfig, req model.TokenRequest) {
+	if providerConfig(c).BridgeCallback {
+		handleBridgeCodeGrant(c, client, req)
+		return
+	}
+
+	// 1. 取出当前提供方下授权时保存的上下文，使用 PKCE 时由 code_verifier 找回，否则按 client_id 和 redirect_uri 找回
+	// 上下文只能使用一次，不存在时不能兑换
+	var txn *model.AuthTransaction
+	var err error
+	if req.CodeVerifier != "" {
+		txn, err = service.TakePendingTransaction(providerConfig(c).Name, req.CodeVerifier)
+	} else {
+		txn, err = service.TakeUnboundTransaction(providerConfig(c).Name, req.ClientID, req.RedirectURI)
+	}
+	if errors.Is(err, service.ErrTransactionNotFound) {
+		utils.ErrorLogger.Printf("No pending authorization matches the token request of client: %s", req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "no authorization request matches the code_verifier, client_id and redirect_uri"})
+		return
+	} else if err != nil {
+		utils.ErrorLogger.Printf("Failed to load pending authorization for client: %s, error: %v", req.ClientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to load authorization request"})
+		return
+	}
+	if txn.ClientID != req.ClientID || txn.RedirectURI != req.RedirectURI {
+		utils.ErrorLogger.Printf("Authorization request of client: %s was redeemed by client: %s", txn.ClientID, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "client_id or redirect_uri does not match the authorization request"})
+		return
+	}
+
+	// 2. 将 OP 授权码标记为已兑换，重放的授权码不能再次签发 ID Token
+	claimed, err := service.ClaimAuthCode(providerConfig(c), req.Code)
 	if err != nil {
-		utils.ErrorLogger.Printf("Failed to proxy to OP token endpoint: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": err.Error()})
+		utils.ErrorLogger.Printf("Failed to claim authorization code for client: %s, error: %v", req.ClientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to redeem authorization code"})
+		return
+	}
+	if !claimed {
+		utils.ErrorLogger.Printf("Authorization code replayed by client: %s", req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "authorization code has already been used"})
 		return
 	}
 
-	// 3. 构建响应
-	resp := model.TokenResponse{
+	// 3. 使用授权码向 OP 兑换令牌
+	exchangeAuthorizationCode(c, client, req, req, txn)
+}
+
+// handleBridgeCodeGrant 兑换桥接回调模式下由桥接服务签发的授权码
+func handleBridgeCodeGrant(c *gin.Context, client *model.ClientConfig, req model.TokenRequest) {
+	// 1. 取出当前提供方签发的授权码对应的授权事务，授权码只能兑换一次
+	txn, err := service.TakeAuthCode(providerConfig(c).Name, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrTransactionNotFound) {
+			utils.ErrorLogger.Printf("Authorization code not found or already used for client: %s", req.ClientID)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "invalid or expired authorization code"})
+			return
+		}
+		utils.ErrorLogger.Printf("Failed to load authorization code for client: %s, error: %v", req.ClientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to load authorization code"})
+		return
+	}
+
+	// 2. 授权码必须由同一 client 以相同的 redirect_uri 兑换
+	if txn.ClientID != req.ClientID || txn.RedirectURI != req.RedirectURI {
+		utils.ErrorLogger.Printf("Authorization code issued to client: %s was presented by client: %s", txn.ClientID, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "client_id or redirect_uri does not match the authorization request"})
+		return
+	}
+
+	// 3. 使用 OP 授权码和桥接回调地址向 OP 兑换令牌
+	opReq := req
+	opReq.Code = txn.OPCode
+	opReq.RedirectURI = txn.CallbackURL
+	exchangeAuthorizationCode(c, client, req, opReq, txn)
+}
+
+// exchangeAuthorizationCode 校验 PKCE 后向 OP 兑换授权码，并按授权上下文中的 scope 和 nonce 签发 ID Token
+func exchangeAuthorizationCode(c *gin.Context, client *model.ClientConfig, req, opReq model.TokenRequest, txn *model.AuthTransaction) {
+	// 1. OP 不支持 PKCE 时由桥接服务校验 code_verifier；公开客户端或携带 code_verifier 的请求必须有授权时保存的 code_challenge
+	cfg := providerConfig(c)
+	if !cfg.OPSupportsPKCE && txn.CodeChallenge == "" &&
+		(req.CodeVerifier != "" || service.TranslatesClientCredentials(cfg) && service.IsPublicClient(client)) {
+		utils.ErrorLogger.Printf("No code_challenge stored for PKCE-protected request of client: %s", req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "authorization request has no code_challenge"})
+		return
+	}
+	if txn.CodeChallenge != "" && !service.VerifyCodeChallenge(txn.CodeChallenge, txn.CodeChallengeMethod, req.CodeVerifier) {
+		utils.ErrorLogger.Printf("PKCE verification failed for client: %s", req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "code_verifier does not match code_challenge"})
+		return
+	}
+
+	// 2. 向 OP 代理请求
+	opResp, ok := proxyTokenRequest(c, opReq)
+	if !ok {
+		return
+	}
+
+	// 3. 构建响应，如果授权请求的 scope 包含 openid，则生成 ID Token
+	if resp, ok := buildTokenResponse(c, req.ClientID, txn.Scope, txn.Nonce, opResp); ok {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// buildTokenResponse 根据 OP 返回的令牌构建响应，scope 包含 openid 时签发 ID Token，失败时直接写入错误响应
+func buildTokenResponse(c *gin.Context, clientID, scope, nonce string, opResp *model.OPTokenResponse) (*model.TokenResponse, bool) {
+	resp := &model.TokenResponse{
 		AccessToken:  opResp.AccessToken,
 		TokenType:    opResp.TokenType,
 		RefreshToken: opResp.RefreshToken,
 		ExpiresIn:    opResp.ExpiresIn,
 	}
 
-	// 4. 如果 scope 包含 openid，则生成 ID Token
-	if strings.Contains(scope, "openid") {
-		// 获取用户信息
-		userInfo, err := service.GetUserInfoFromOP(opResp.AccessToken)
-		if err != nil {
-			utils.ErrorLogger.Printf("Failed to get user info: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to get user info"})
-			return
+	if !strings.Contains(scope, "openid") {
+		// 桥接服务以自己的凭据访问 OP 时，OP 无法区分 RP，需要记录 refresh_token 所属的 client
+		if resp.RefreshToken != "" && service.TranslatesClientCredentials(providerConfig(c)) {
+			saveRefreshRecord(c, resp.RefreshToken, &model.RefreshTokenRecord{
+				ClientID: clientID,
+				Scope:    scope,
+				Provider: providerConfig(c).Name,
+			})
 		}
+		return resp, true
+	}
 
-		// 获取 Issuer
-		issuer := config.AppConfig.Issuer
-		if issuer == "" {
-			// Determine scheme based on TLS, X-Forwarded-Proto, or default to http
-			var scheme string
-			if c.Request.TLS != nil {
-				scheme = "https"
-			} else if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
-				scheme = proto
-			} else {
-				scheme = "http"
-			}
-
-			// Use the Host from the request for better reverse proxy compatibility
-			host := c.Request.Host
-			issuer = scheme + "://" + host
+	// 获取用户信息
+	userInfo, err := service.GetUserInfoFromOP(providerConfig(c), opResp.AccessToken)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to get user info: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to get user info"})
+		return nil, false
+	}
+
+	// 生成 ID Token
+	idToken, err := service.GenerateIDToken(providerConfig(c), resolveIssuer(c), clientID, nonce, userInfo)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to generate ID token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to generate ID token"})
+		return nil, false
+	}
+	resp.IDToken = idToken
+
+	// 记录 refresh_token 对应的 subject 和 audience，供刷新时重新签发 ID Token
+	if resp.RefreshToken != "" {
+		subject := service.UserSubject(userInfo)
+		if subject == "" {
+			utils.ErrorLogger.Printf("User info has no sub claim, ID token will not be re-issued on refresh for client: %s", clientID)
+		}
+		if subject != "" || service.TranslatesClientCredentials(providerConfig(c)) {
+			saveRefreshRecord(c, resp.RefreshToken, &model.RefreshTokenRecord{
+				Subject:  subject,
+				ClientID: clientID,
+				Scope:    scope,
+				Provider: providerConfig(c).Name,
+			})
 		}
+	}
 
-		// 生成 ID Token
-		idToken, err := service.GenerateIDToken(issuer, req.ClientID, req.RedirectURI, userInfo)
-		if err != nil {
-			utils.ErrorLogger.Printf("Failed to generate ID token: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to generate ID token"})
-			return
+	return resp, true
+}
+
+// saveRefreshRecord 保存 refresh_token 对应的授权信息，失败时只记录日志
+func saveRefreshRecord(c *gin.Context, refreshToken string, record *model.RefreshTokenRecord) {
+	if err := service.SaveRefreshToken(providerConfig(c), refreshToken, record); err != nil {
+		utils.ErrorLogger.Printf("Failed to save refresh token record for client: %s, error: %v", record.ClientID, err)
+	}
+}
+
+func handleRefreshTokenGrant(c *gin.Context, req model.TokenRequest) {
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "refresh_token is required"})
+		return
+	}
+
+	// 1. 查找原始授权信息，refresh_token 只能由原 client 使用
+	record, err := service.GetRefreshToken(req.RefreshToken)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to load refresh token record for client: %s, error: %v", req.ClientID, err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "server_error", "error_description": "failed to load refresh token"})
+		return
+	}
+	if record == nil && service.TranslatesClientCredentials(providerConfig(c)) {
+		// OP 颁发给桥接服务的 refresh_token 只能由记录中的 client 使用
+		utils.ErrorLogger.Printf("Unknown refresh token presented by client: %s", req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "invalid or expired refresh_token"})
+		return
+	}
+	if record != nil && record.Provider != providerConfig(c).Name {
+		utils.ErrorLogger.Printf("Refresh token of provider: %s presented to provider: %s", record.Provider, providerConfig(c).Name)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "refresh_token was issued by another provider"})
+		return
+	}
+	if record != nil && record.ClientID != req.ClientID {
+		utils.ErrorLogger.Printf("Refresh token issued to client: %s was presented by client: %s", record.ClientID, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "refresh_token was issued to another client"})
+		return
+	}
+
+	// 2. 向 OP 代理请求
+	opResp, ok := proxyTokenRequest(c, req)
+	if !ok {
+		return
+	}
+
+	// 3. 构建响应
+	resp := model.TokenResponse{
+		AccessToken:  opResp.AccessToken,
+		TokenType:    opResp.TokenType,
+		RefreshToken: opResp.RefreshToken,
+		ExpiresIn:    opResp.ExpiresIn,
+	}
+
+	// 原始授权未签发 ID Token 时只返回 OP 的令牌
+	if record == nil || record.Subject == "" {
+		utils.DebugLogger.Printf("No refresh token subject for client: %s, skipping ID token", req.ClientID)
+		if record != nil {
+			rotateRefreshRecord(c, req.RefreshToken, resp.RefreshToken, record)
 		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
 
-		resp.IDToken = idToken
+	// 4. 重新获取用户信息并签发 ID Token，sub 和 aud 必须与原始 ID Token 一致
+	userInfo, err := service.GetUserInfoFromOP(providerConfig(c), opResp.AccessToken)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to get user info: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to get user info"})
+		return
+	}
+	if subject := service.UserSubject(userInfo); subject != record.Subject {
+		utils.ErrorLogger.Printf("Subject changed on refresh for client: %s, expected: %s, got: %s", req.ClientID, record.Subject, subject)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "subject does not match the original authorization"})
+		return
 	}
 
+	idToken, err := service.GenerateIDToken(providerConfig(c), resolveIssuer(c), record.ClientID, "", userInfo)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to generate ID token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "failed to generate ID token"})
+		return
+	}
+	resp.IDToken = idToken
+
+	// 5. OP 轮换了 refresh_token 时，将授权信息迁移到新令牌上
+	rotateRefreshRecord(c, req.RefreshToken, resp.RefreshToken, record)
+
 	c.JSON(http.StatusOK, resp)
 }
+
+// rotateRefreshRecord 将授权信息迁移到 OP 轮换后的 refresh_token 上；未返回新令牌时 RP 继续使用原令牌
+func rotateRefreshRecord(c *gin.Context, oldToken, newToken string, record *model.RefreshTokenRecord) {
+	if newToken == "" || newToken == oldToken {
+		return
+	}
+	saveRefreshRecord(c, newToken, record)
+	if err := service.DeleteRefreshToken(oldToken); err != nil {
+		utils.ErrorLogger.Printf("Failed to delete rotated refresh token record for client: %s, error: %v", record.ClientID, err)
+	}
+}
+
+// proxyTokenRequest 向 OP 的 token 端点转发请求，失败时直接写入错误响应
+func proxyTokenRequest(c *gin.Context, req model.TokenRequest) (*model.OPTokenResponse, bool) {
+	opResp, err := service.ProxyToOPTokenEndpoint(providerConfig(c), req)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to proxy to OP token endpoint: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": err.Error()})
+		return nil, false
+	}
+
+	// OP 返回 OAuth2 错误时原样透传给 RP
+	if opResp.Error != "" {
+		utils.ErrorLogger.Printf("OP token endpoint returned error: %s for client: %s", opResp.Error, req.ClientID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": opResp.Error, "error_description": opResp.ErrorDescription})
+		return nil, false
+	}
+
+	return opResp, true
+}
+
+// resolveIssuer 获取 Issuer，未配置时根据请求推断
+func resolveIssuer(c *gin.Context) string {
+	cfg := providerConfig(c)
+	issuer := cfg.Issuer
+	if issuer == "" {
+		// Determine scheme based on TLS, X-Forwarded-Proto, or default to http
+		var scheme string
+		if c.Request.TLS != nil {
+			scheme = "https"
+		} else if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		} else {
+			scheme = "http"
+		}
+
+		// Use the Host from the request for better reverse proxy compatibility
+		host := c.Request.Host
+		issuer = scheme + "://" + host + cfg.PathPrefix
+	}
+	return issuer
+}
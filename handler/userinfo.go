@@ -25,7 +25,7 @@ func HandleUserInfo(c *gin.Context) {
 	}
 
 	// 2. 调用 OP 获取用户信息
-	userInfo, err := service.GetUserInfoFromOP(accessToken)
+	userInfo, err := service.GetUserInfoFromOP(providerConfig(c), accessToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": fmt.Sprintf("failed to get user info from OP: %v", err)})
 		return
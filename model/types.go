@@ -1,45 +1,174 @@
 package model
 
+// Config 桥接服务配置
+// op_client_id 非空时桥接服务以自己的 OP 应用凭据访问 OP，RP 使用客户端注册表中由桥接服务分发的凭据
+// name、path_prefix、host 只在 providers 的条目中使用；Providers 由 config.LoadConfig 将各条目与顶层配置合并生成
+// provider 选择内置的平台预设，预设提供 OP 端点、scope 映射、属性映射和 op_dialect 等默认值，配置文件中的设置优先
 type Config struct {
-	OPAuthURL       string            `mapstructure:"op_authorize_url"`
-	OPTokenURL      string            `mapstructure:"op_token_url"`
-	OPUserInfoURL   string            `mapstructure:"op_userinfo_url"`
-	Issuer          string            `mapstructure:"issuer"`
-	IDTokenLifetime int               `mapstructure:"id_token_lifetime"`
-	NonceCacheTTL   int               `mapstructure:"nonce_cache_ttl"`
-	SigningAlg      string            `mapstructure:"id_token_signing_alg"`
-	ScopeMapping    map[string]string `mapstructure:"scope_mapping"`
-	AttrMapping     map[string]string `mapstructure:"user_attribute_mapping"`
-	RedisAddr       string            `mapstructure:"redis_addr"`
-	PrivateKeyPath  string            `mapstructure:"private_key_path"`
-	PublicKeyPath   string            `mapstructure:"public_key_path"`
+	Name                     string             `mapstructure:"name"`
+	PathPrefix               string             `mapstructure:"path_prefix"`
+	Host                     string             `mapstructure:"host"`
+	Preset                   string             `mapstructure:"provider"`
+	OPAuthURL                string             `mapstructure:"op_authorize_url"`
+	OPTokenURL               string             `mapstructure:"op_token_url"`
+	OPUserInfoURL            string             `mapstructure:"op_userinfo_url"`
+	OPClientID               string             `mapstructure:"op_client_id"`
+	OPClientSecret           string             `mapstructure:"op_client_secret"`
+	OPTokenAuthMethod        string             `mapstructure:"op_token_auth_method"`
+	OPDialect                string             `mapstructure:"op_dialect"`
+	OPAuthorizeRequest       OPRequestConfig    `mapstructure:"op_authorize_request"`
+	OPTokenRequest           OPRequestConfig    `mapstructure:"op_token_request"`
+	OPRequiredScopes         []string           `mapstructure:"op_required_scopes"`
+	Issuer                   string             `mapstructure:"issuer"`
+	IDTokenLifetime          int                `mapstructure:"id_token_lifetime"`
+	NonceCacheTTL            int                `mapstructure:"nonce_cache_ttl"`
+	RefreshTokenTTL          int                `mapstructure:"refresh_token_ttl"`
+	SigningAlg               string             `mapstructure:"id_token_signing_alg"`
+	OPSupportsPKCE           bool               `mapstructure:"op_supports_pkce"`
+	BridgeCallback           bool               `mapstructure:"bridge_callback"`
+	RequirePKCE              bool               `mapstructure:"require_pkce"`
+	CallbackURL              string             `mapstructure:"callback_url"`
+	AuthCodeTTL              int                `mapstructure:"auth_code_ttl"`
+	ScopeMapping             map[string]string  `mapstructure:"scope_mapping"`
+	AttrMapping              map[string]string  `mapstructure:"user_attribute_mapping"`
+	Store                    string             `mapstructure:"store"`
+	RedisAddr                string             `mapstructure:"redis_addr"`
+	Redis                    RedisConfig        `mapstructure:"redis"`
+	SQL                      SQLConfig          `mapstructure:"sql"`
+	Memory                   MemoryConfig       `mapstructure:"memory"`
+	PrivateKeyPath           string             `mapstructure:"private_key_path"`
+	PublicKeyPath            string             `mapstructure:"public_key_path"`
+	PrivateKeyPassphraseFile string             `mapstructure:"private_key_passphrase_file"`
+	AutoGenerateKey          bool               `mapstructure:"auto_generate_key"`
+	SigningKeyStorage        string             `mapstructure:"signing_key_storage"`
+	SigningKeys              []SigningKeyConfig `mapstructure:"signing_keys"`
+	SigningKeysDir           string             `mapstructure:"signing_keys_dir"`
+	SigningKeysPrepublish    int                `mapstructure:"signing_keys_prepublish"`
+	Clients                  []ClientConfig     `mapstructure:"clients"`
+	Registration             RegistrationConfig `mapstructure:"registration"`
+	Providers                []*Config          `mapstructure:"-"`
+}
+
+// OPRequestConfig 发往 OP 的授权或令牌请求的模板
+// params 将标准参数名（如 client_id）改为 OP 使用的名称，改为空字符串时不发送该参数；
+// extra_params 为 name=value 形式的固定参数，值中的 {client_id} 等占位符替换为对应标准参数的值；
+// encoding 和 headers 只用于令牌请求，encoding 为 form（默认）或 json
+type OPRequestConfig struct {
+	Encoding    string            `mapstructure:"encoding"`
+	Params      map[string]string `mapstructure:"params"`
+	ExtraParams []string          `mapstructure:"extra_params"`
+	Headers     map[string]string `mapstructure:"headers"`
+}
+
+// RedisConfig Redis 连接配置
+// master_name 非空时使用 Sentinel（addrs 为 Sentinel 地址），addrs 包含多个地址时使用 Cluster
+// failure_policy 决定 Redis 不可达时的行为：fail、fallback（默认）或 degrade
+type RedisConfig struct {
+	Addrs                []string       `mapstructure:"addrs"`
+	Username             string         `mapstructure:"username"`
+	Password             string         `mapstructure:"password"`
+	DB                   int            `mapstructure:"db"`
+	MasterName           string         `mapstructure:"master_name"`
+	SentinelPassword     string         `mapstructure:"sentinel_password"`
+	KeyPrefix            string         `mapstructure:"key_prefix"`
+	TLS                  RedisTLSConfig `mapstructure:"tls"`
+	FailurePolicy        string         `mapstructure:"failure_policy"`
+	ReconnectInterval    int            `mapstructure:"reconnect_interval"`
+	MaxReconnectInterval int            `mapstructure:"max_reconnect_interval"`
+}
+
+// RedisTLSConfig Redis TLS 配置
+type RedisTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// SQLConfig SQL 存储配置
+type SQLConfig struct {
+	Driver          string `mapstructure:"driver"`
+	DSN             string `mapstructure:"dsn"`
+	CleanupInterval int    `mapstructure:"cleanup_interval"`
+}
+
+// MemoryConfig 内存存储配置，容量为 0 时使用默认值，小于 0 时不限制
+type MemoryConfig struct {
+	MaxEntries       int   `mapstructure:"max_entries"`
+	MaxBytes         int64 `mapstructure:"max_bytes"`
+	MaxPinnedEntries int   `mapstructure:"max_pinned_entries"`
+	MaxPinnedBytes   int64 `mapstructure:"max_pinned_bytes"`
+	CleanupInterval  int   `mapstructure:"cleanup_interval"`
+}
+
+// ClientConfig 客户端注册表中的单个 RP
+// client_secret 非空时 /token 校验 RP 提交的密钥，jwks 或 jwks_uri 用于校验 private_key_jwt；
+// token_endpoint_auth_method 非空时只接受该认证方式；allowed_scopes 为空时不限制 scope；
+// id_token_lifetime、refresh_token_ttl 为 0 时使用全局配置；claims 非空时 ID Token 只包含列出的用户属性（sub 始终保留）
+type ClientConfig struct {
+	ClientID                string   `mapstructure:"client_id"`
+	ClientSecret            string   `mapstructure:"client_secret"`
+	ClientSecretSHA256      string   `mapstructure:"-"` // 动态注册的客户端只保存 client_secret 的摘要
+	TokenEndpointAuthMethod string   `mapstructure:"token_endpoint_auth_method"`
+	JWKS                    *JWKS    `mapstructure:"jwks"`
+	JWKSURI                 string   `mapstructure:"jwks_uri"`
+	RedirectURIs            []string `mapstructure:"redirect_uris"`
+	AllowedScopes           []string `mapstructure:"allowed_scopes"`
+	IDTokenLifetime         int      `mapstructure:"id_token_lifetime"`
+	RefreshTokenTTL         int      `mapstructure:"refresh_token_ttl"`
+	Claims                  []string `mapstructure:"claims"`
+}
+
+// RegistrationConfig 动态客户端注册配置（RFC 7591/7592），注册请求必须携带 initial_access_token
+type RegistrationConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	InitialAccessToken string `mapstructure:"initial_access_token"`
+}
+
+// SigningKeyConfig 密钥集中单个签名密钥的配置，时间使用 RFC 3339 格式，留空表示不限制
+type SigningKeyConfig struct {
+	PrivateKeyPath string `mapstructure:"private_key_path"`
+	NotBefore      string `mapstructure:"not_before"`
+	NotAfter       string `mapstructure:"not_after"`
 }
 
 type Discovery struct {
-	Issuer                           string   `json:"issuer"`
-	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
-	TokenEndpoint                    string   `json:"token_endpoint"`
-	UserInfoEndpoint                 string   `json:"userinfo_endpoint"`
-	JwksURI                          string   `json:"jwks_uri"`
-	ScopesSupported                  []string `json:"scopes_supported"`
-	ResponseTypesSupported           []string `json:"response_types_supported"`
-	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	UserInfoEndpoint                           string   `json:"userinfo_endpoint"`
+	JwksURI                                    string   `json:"jwks_uri"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint,omitempty"`
 }
 
 type TokenRequest struct {
-	GrantType    string `form:"grant_type" json:"grant_type"`
-	Code         string `form:"code" json:"code"`
-	RedirectURI  string `form:"redirect_uri" json:"redirect_uri"`
-	ClientID     string `form:"client_id" json:"client_id"`
-	ClientSecret string `form:"client_secret" json:"client_secret"`
+	GrantType           string `form:"grant_type" json:"grant_type"`
+	Code                string `form:"code" json:"code"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	ClientSecret        string `form:"client_secret" json:"client_secret"`
+	CodeVerifier        string `form:"code_verifier" json:"code_verifier"`
+	RefreshToken        string `form:"refresh_token" json:"refresh_token"`
+	Scope               string `form:"scope" json:"scope"`
+	ClientAssertionType string `form:"client_assertion_type" json:"client_assertion_type"`
+	ClientAssertion     string `form:"client_assertion" json:"client_assertion"`
 }
 
 type OPTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	Error        string `json:"error,omitempty"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
 }
 
 type TokenResponse struct {
@@ -50,12 +179,56 @@ type TokenResponse struct {
 	IDToken      string `json:"id_token,omitempty"`
 }
 
+// AuthTransaction 记录一次授权请求的上下文，在桥接回调模式下以随机事务 ID 和桥接授权码为键保存
+type AuthTransaction struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	CallbackURL         string `json:"callback_url"`
+	Provider            string `json:"provider,omitempty"`
+	OPCode              string `json:"op_code,omitempty"`
+}
+
+// RefreshTokenRecord 记录 refresh_token 对应的原始授权信息，用于刷新时重新签发 ID Token
+type RefreshTokenRecord struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// ClientRegistration 动态注册客户端的元数据，即 /register 的请求和响应（RFC 7591/7592）
+type ClientRegistration struct {
+	ClientID                string   `json:"client_id,omitempty"`
+	ClientSecret            string   `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64    `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   *int64   `json:"client_secret_expires_at,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	ClientName              string   `json:"client_name,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	JWKSURI                 string   `json:"jwks_uri,omitempty"`
+	JWKS                    *JWKS    `json:"jwks,omitempty"`
+	RegistrationAccessToken string   `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string   `json:"registration_client_uri,omitempty"`
+}
+
 type JWK struct {
 	KTY string `json:"kty"`
 	Use string `json:"use"`
 	Kid string `json:"kid"`
-	N   string `json:"n"`
-	E   string `json:"e"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
 }
 
 type JWKS struct {
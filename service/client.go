@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"oidc-bridge/model"
+)
+
+// ErrUnknownClient client_id 不在客户端注册表中
+var ErrUnknownClient = errors.New("unknown client")
+
+// LookupClient 在提供方的客户端注册表中查找 client_id，先查配置中的 clients，再查动态注册的客户端
+// 未配置 clients 且未开放动态注册时不限制 RP，返回 nil, nil
+func LookupClient(cfg *model.Config, clientID string) (*model.ClientConfig, error) {
+	if len(cfg.Clients) == 0 && !RegistrationEnabled(cfg) {
+		return nil, nil
+	}
+	for i := range cfg.Clients {
+		if cfg.Clients[i].ClientID == clientID {
+			return &cfg.Clients[i], nil
+		}
+	}
+	if !RegistrationEnabled(cfg) {
+		return nil, ErrUnknownClient
+	}
+
+	record, err := loadRegisteredClient(cfg, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return record.clientConfig(), nil
+}
+
+// findClient 查找已注册的 client，未配置注册表或 client 未注册时返回 nil
+func findClient(cfg *model.Config, clientID string) *model.ClientConfig {
+	client, _ := LookupClient(cfg, clientID)
+	return client
+}
+
+// TranslatesClientCredentials 判断桥接服务是否以自己的 OP 应用凭据代替 RP 的凭据访问 OP
+func TranslatesClientCredentials(cfg *model.Config) bool {
+	return cfg.OPClientID != ""
+}
+
+// OPClientID 返回发送给 OP 的 client_id
+func OPClientID(cfg *model.Config, clientID string) string {
+	if TranslatesClientCredentials(cfg) {
+		return cfg.OPClientID
+	}
+	return clientID
+}
+
+// RedirectURIAllowed 检查 redirect_uri 是否为 client 注册的回调地址之一，按字符串精确匹配
+func RedirectURIAllowed(client *model.ClientConfig, redirectURI string) bool {
+	if client == nil {
+		return true
+	}
+	for _, allowed := range client.RedirectURIs {
+		if allowed == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// DisallowedScopes 返回请求的 scope 中 client 无权使用的部分
+func DisallowedScopes(client *model.ClientConfig, scope string) []string {
+	if client == nil || len(client.AllowedScopes) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(client.AllowedScopes))
+	for _, s := range client.AllowedScopes {
+		allowed[s] = true
+	}
+
+	var disallowed []string
+	for _, s := range strings.Fields(scope) {
+		if !allowed[s] {
+			disallowed = append(disallowed, s)
+		}
+	}
+	return disallowed
+}
+
+// idTokenLifetime 返回 client 的 ID Token 有效期，未单独配置时使用提供方配置
+func idTokenLifetime(cfg *model.Config, clientID string) int {
+	if client := findClient(cfg, clientID); client != nil && client.IDTokenLifetime > 0 {
+		return client.IDTokenLifetime
+	}
+	return cfg.IDTokenLifetime
+}
+
+// maxIDTokenLifetime 返回提供方下所有 client 中最长的 ID Token 有效期，用于决定停用密钥的发布时长
+func maxIDTokenLifetime(cfg *model.Config) time.Duration {
+	lifetime := cfg.IDTokenLifetime
+	for _, client := range cfg.Clients {
+		if client.IDTokenLifetime > lifetime {
+			lifetime = client.IDTokenLifetime
+		}
+	}
+	return time.Duration(lifetime) * time.Second
+}
+
+// filterClaims 按 client 的 claims 配置过滤写入 ID Token 的用户属性
+func filterClaims(cfg *model.Config, clientID string, claims map[string]interface{}) {
+	client := findClient(cfg, clientID)
+	if client == nil || len(client.Claims) == 0 {
+		return
+	}
+	allowed := map[string]bool{"sub": true}
+	for _, claim := range client.Claims {
+		allowed[claim] = true
+	}
+	for claim := range claims {
+		if !allowed[claim] {
+			delete(claims, claim)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 客户端在 token 端点的认证方式（OIDC Core 9）
+const (
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretJWT   = "client_secret_jwt"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+)
+
+// ClientAssertionTypeJWTBearer client_assertion_type 唯一支持的取值（RFC 7523）
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const (
+	// jwksFetchTimeout 获取 client 的 jwks_uri 的超时时间
+	jwksFetchTimeout = 10 * time.Second
+	// jwksCacheTTL client 公钥集的缓存时间
+	jwksCacheTTL = 10 * time.Minute
+	// jwksRefetchInterval 断言的 kid 不在缓存中时重新获取公钥集的最短间隔，避免以随机 kid 放大对 jwks_uri 的请求
+	jwksRefetchInterval = time.Minute
+	// maxJWKSSize client 公钥集响应的最大字节数
+	maxJWKSSize = 64 << 10
+)
+
+// JWKSHTTPClient 获取 client 的 jwks_uri 使用的 HTTP 客户端
+var JWKSHTTPClient = &http.Client{Timeout: jwksFetchTimeout}
+
+// cachedJWKS 缓存的 client 公钥集
+type cachedJWKS struct {
+	keys      []model.JWK
+	fetchedAt time.Time
+}
+
+// clientJWKS 按 client_id 和 jwks_uri 缓存 client 的公钥集
+var clientJWKS = struct {
+	sync.Mutex
+	entries map[string]cachedJWKS
+}{entries: make(map[string]cachedJWKS)}
+
+// ErrClientAuthentication 客户端认证失败
+var ErrClientAuthentication = errors.New("client authentication failed")
+
+// TokenEndpointAuthMethods token 端点支持的客户端认证方式，在发现文档中公布
+var TokenEndpointAuthMethods = []string{AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodClientSecretJWT, AuthMethodPrivateKeyJWT, AuthMethodNone}
+
+// ClientAssertionAlgs 客户端断言支持的签名算法
+var ClientAssertionAlgs = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "EdDSA"}
+
+// ClientCredentials RP 在 token 请求中提交的客户端凭据
+type ClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+	Assertion    string
+	// Method 由请求的形式推断：client_secret_basic、client_secret_post 或 none，提交断言时由断言的算法决定
+	Method string
+}
+
+// IsPublicClient 判断 client 是否为无法保存凭据的公开客户端
+func IsPublicClient(client *model.ClientConfig) bool {
+	if client == nil || client.TokenEndpointAuthMethod == AuthMethodNone {
+		return true
+	}
+	return client.ClientSecret == "" && client.ClientSecretSHA256 == "" && client.JWKS == nil && client.JWKSURI == ""
+}
+
+// clientSecretMatches 以常量时间比较 RP 提交的 client_secret，动态注册的客户端比较摘要
+func clientSecretMatches(client *model.ClientConfig, secret string) bool {
+	if client.ClientSecretSHA256 != "" {
+		return subtle.ConstantTimeCompare([]byte(client.ClientSecretSHA256), []byte(hashSecret(secret))) == 1
+	}
+	return client.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(secret)) == 1
+}
+
+// AssertionClientID 从未校验的客户端断言中取出 sub，RP 只提交断言而未提交 client_id 时使用
+func AssertionClientID(assertion string) string {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, claims); err != nil {
+		return ""
+	}
+	sub, _ := claims.GetSubject()
+	return sub
+}
+
+// AuthenticateClient 按 client 的配置校验 RP 提交的凭据
+// audiences 为客户端断言可接受的 aud（token 端点地址和 Issuer）；client 为 nil（未配置注册表）时只接受客户端密钥
+func AuthenticateClient(client *model.ClientConfig, creds ClientCredentials, audiences []string) error {
+	if client == nil {
+		if creds.Assertion != "" {
+			return fmt.Errorf("%w: client_assertion requires a registered client", ErrClientAuthentication)
+		}
+		return nil
+	}
+
+	// 1. 断言的签名算法决定认证方式：HMAC 为 client_secret_jwt，其余为 private_key_jwt
+	method := creds.Method
+	if creds.Assertion != "" {
+		method = AuthMethodPrivateKeyJWT
+		if token, _, err := jwt.NewParser().ParseUnverified(creds.Assertion, jwt.MapClaims{}); err == nil && strings.HasPrefix(token.Method.Alg(), "HS") {
+			method = AuthMethodClientSecretJWT
+		}
+	}
+	if client.TokenEndpointAuthMethod != "" && client.TokenEndpointAuthMethod != method {
+		return fmt.Errorf("%w: client must authenticate with %s", ErrClientAuthentication, client.TokenEndpointAuthMethod)
+	}
+
+	// 2. 按认证方式校验凭据
+	switch method {
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost:
+		if IsPublicClient(client) {
+			return nil
+		}
+		if !clientSecretMatches(client, creds.ClientSecret) {
+			return ErrClientAuthentication
+		}
+	case AuthMethodClientSecretJWT:
+		if client.ClientSecret == "" {
+			return fmt.Errorf("%w: client has no client_secret", ErrClientAuthentication)
+		}
+		return verifyClientAssertion(client, creds.Assertion, audiences, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+			}
+			return []byte(client.ClientSecret), nil
+		})
+	case AuthMethodPrivateKeyJWT:
+		return verifyClientAssertion(client, creds.Assertion, audiences, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			keys, err := clientPublicKeys(client, kid)
+			if err != nil {
+				return nil, err
+			}
+			return selectClientKey(keys, token)
+		})
+	default:
+		if !IsPublicClient(client) {
+			return fmt.Errorf("%w: client credentials are required", ErrClientAuthentication)
+		}
+	}
+	return nil
+}
+
+// verifyClientAssertion 校验客户端断言（RFC 7523 3）：iss 和 sub 为 client_id，aud 为 token 端点，必须有 exp 和 jti，jti 不能重放
+func verifyClientAssertion(client *model.ClientConfig, assertion string, audiences []string, keyFunc jwt.Keyfunc) error {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(ClientAssertionAlgs),
+		jwt.WithIssuer(client.ClientID),
+		jwt.WithSubject(client.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(assertion, claims, keyFunc); err != nil {
+		return fmt.Errorf("%w: %v", ErrClientAuthentication, err)
+	}
+
+	if !assertionAudienceMatches(claims, audiences) {
+		return fmt.Errorf("%w: client_assertion audience does not match", ErrClientAuthentication)
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return fmt.Errorf("%w: client_assertion jti is required", ErrClientAuthentication)
+	}
+	exp, _ := claims.GetExpirationTime()
+	ttl := time.Until(exp.Time)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	sum := sha256.Sum256([]byte(client.ClientID + ":" + jti))
+	stored, err := GlobalStore.SetNX("assertion:"+hex.EncodeToString(sum[:]), "1", ttl)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return fmt.Errorf("%w: client_assertion has already been used", ErrClientAuthentication)
+	}
+	return nil
+}
+
+func assertionAudienceMatches(claims jwt.MapClaims, audiences []string) bool {
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	for _, got := range aud {
+		for _, want := range audiences {
+			if got == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateJWKSURI 检查 client 的 jwks_uri，只接受 https 地址
+func ValidateJWKSURI(jwksURI string) error {
+	parsed, err := url.Parse(jwksURI)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.New("jwks_uri must be an absolute https URL")
+	}
+	return nil
+}
+
+// clientPublicKeys 返回 client 用于 private_key_jwt 的公钥
+// jwks_uri 的公钥集缓存 jwksCacheTTL，断言的 kid 不在缓存中时（client 轮换了密钥）至多每 jwksRefetchInterval 重新获取一次
+func clientPublicKeys(client *model.ClientConfig, kid string) ([]model.JWK, error) {
+	if client.JWKS != nil {
+		return client.JWKS.Keys, nil
+	}
+	if client.JWKSURI == "" {
+		return nil, errors.New("client has no jwks or jwks_uri")
+	}
+
+	key := client.ClientID + "\x00" + client.JWKSURI
+	clientJWKS.Lock()
+	defer clientJWKS.Unlock()
+	cached, ok := clientJWKS.entries[key]
+	age := time.Since(cached.fetchedAt)
+	if ok && age < jwksCacheTTL && (hasKeyID(cached.keys, kid) || age < jwksRefetchInterval) {
+		return cached.keys, nil
+	}
+
+	keys, err := fetchClientJWKS(client.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	clientJWKS.entries[key] = cachedJWKS{keys: keys, fetchedAt: time.Now()}
+	utils.DebugLogger.Printf("Fetched jwks_uri of client: %s", client.ClientID)
+	return keys, nil
+}
+
+// fetchClientJWKS 获取 jwks_uri 上的公钥集，响应不能超过 maxJWKSSize
+func fetchClientJWKS(jwksURI string) ([]model.JWK, error) {
+	if err := ValidateJWKSURI(jwksURI); err != nil {
+		return nil, err
+	}
+	resp, err := JWKSHTTPClient.Get(jwksURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch client jwks_uri: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("client jwks_uri returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client jwks: %v", err)
+	}
+	if len(data) > maxJWKSSize {
+		return nil, fmt.Errorf("client jwks exceeds %d bytes", maxJWKSSize)
+	}
+	var jwks model.JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode client jwks: %v", err)
+	}
+	return jwks.Keys, nil
+}
+
+// hasKeyID 判断公钥集中是否有 kid 对应的公钥，kid 为空时任一公钥都可能匹配
+func hasKeyID(keys []model.JWK, kid string) bool {
+	if kid == "" {
+		return len(keys) > 0
+	}
+	for _, jwk := range keys {
+		if jwk.Kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// selectClientKey 按断言头部的 kid 选择公钥，未指定 kid 时取第一个类型与算法匹配的公钥
+func selectClientKey(keys []model.JWK, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+	for _, jwk := range keys {
+		if (kid != "" && jwk.Kid != kid) || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		publicKey, err := PublicKeyFromJWK(jwk)
+		if err != nil {
+			continue
+		}
+		if CheckKeyAlg(publicKey, alg) == nil {
+			return publicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("no client key matches kid %q and alg %s", kid, alg)
+}
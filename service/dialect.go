@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+)
+
+// OP 方言：令牌和用户信息接口不遵循标准 OAuth2 的平台，由 op_dialect 选择，对应的内置预设会自动设置
+const (
+	// DialectDingTalk 钉钉：令牌接口使用 JSON 请求体和驼峰字段，用户信息接口通过 x-acs-dingtalk-access-token 头传递令牌
+	DialectDingTalk = "dingtalk"
+	// DialectWeCom 企业微信：授权码需用应用的 access_token 在用户信息接口换取成员身份
+	DialectWeCom = "wecom"
+)
+
+// dingTalkTokenRequest 以 JSON 请求体向钉钉换取用户令牌，并转换为标准的令牌响应
+func dingTalkTokenRequest(cfg *model.Config, req model.TokenRequest) (*model.OPTokenResponse, error) {
+	clientID, clientSecret := opClientCredentials(cfg, req)
+	body := map[string]string{
+		"clientId":     clientID,
+		"clientSecret": clientSecret,
+		"grantType":    req.GrantType,
+	}
+	if req.GrantType == "refresh_token" {
+		body["refreshToken"] = req.RefreshToken
+	} else {
+		body["code"] = req.Code
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest("POST", cfg.OPTokenURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OP token request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+		ExpireIn     int    `json:"expireIn"`
+		Code         string `json:"code"`
+		Message      string `json:"message"`
+	}
+	if err := doJSONRequest(httpReq, &result); err != nil {
+		return nil, fmt.Errorf("failed to request OP token endpoint: %v", err)
+	}
+
+	// 钉钉以 code 和 message 返回错误，授权码无效或过期都视为 invalid_grant
+	if result.AccessToken == "" {
+		return &model.OPTokenResponse{Error: "invalid_grant", ErrorDescription: fmt.Sprintf("%s: %s", result.Code, result.Message)}, nil
+	}
+	return &model.OPTokenResponse{
+		AccessToken:  result.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    result.ExpireIn,
+		RefreshToken: result.RefreshToken,
+	}, nil
+}
+
+// dingTalkUserInfo 获取钉钉用户的个人信息
+func dingTalkUserInfo(cfg *model.Config, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", cfg.OPUserInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create userinfo request: %v", err)
+	}
+	req.Header.Set("x-acs-dingtalk-access-token", accessToken)
+
+	var userInfo map[string]interface{}
+	if err := doJSONRequest(req, &userInfo); err != nil {
+		return nil, fmt.Errorf("failed to request userinfo: %v", err)
+	}
+	if message, ok := userInfo["message"]; ok && userInfo["code"] != nil {
+		return nil, fmt.Errorf("OP userinfo endpoint returned error: %v: %v", userInfo["code"], message)
+	}
+	return userInfo, nil
+}
+
+// weComTokenExpiryMargin 应用 access_token 提前失效的时长，避免使用即将过期的令牌
+const weComTokenExpiryMargin = 5 * time.Minute
+
+// weComUserTTL 企业微信成员身份的保存时长，即返回给 RP 的 access_token 的有效期
+const weComUserTTL = 2 * time.Hour
+
+// weComAppToken 缓存的企业微信应用 access_token
+type weComAppToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// weComTokens 按企业 ID 和应用 Secret 缓存应用 access_token，企业微信限制获取频率
+var weComTokens = struct {
+	sync.Mutex
+	tokens map[string]weComAppToken
+}{tokens: make(map[string]weComAppToken)}
+
+// weComError 企业微信接口的错误码
+type weComError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// weComTokenRequest 用授权码换取企业微信成员身份
+// 应用 access_token 可以调用企业的通讯录等接口，不能交给 RP；成员身份保存在存储中，以随机令牌作为 access_token 返回给 RP
+func weComTokenRequest(cfg *model.Config, req model.TokenRequest) (*model.OPTokenResponse, error) {
+	if req.GrantType == "refresh_token" {
+		return &model.OPTokenResponse{Error: "unsupported_grant_type", ErrorDescription: "WeCom does not issue refresh tokens"}, nil
+	}
+	corpID, secret := opClientCredentials(cfg, req)
+
+	// 1. 用应用 access_token 和授权码获取成员 UserID，应用令牌失效时重新获取一次
+	var identity struct {
+		weComError
+		UserID string `json:"userid"`
+		OpenID string `json:"openid"`
+	}
+	for attempt := 0; ; attempt++ {
+		appToken, err := weComAccessToken(cfg, corpID, secret, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{}
+		query.Set("access_token", appToken)
+		query.Set("code", req.Code)
+		httpReq, err := http.NewRequest("GET", cfg.OPUserInfoURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create userinfo request: %v", err)
+		}
+		if err := doJSONRequest(httpReq, &identity); err != nil {
+			return nil, fmt.Errorf("failed to request userinfo: %v", err)
+		}
+		// 40014: access_token 无效，42001: access_token 已过期
+		if attempt > 0 || identity.ErrCode != 40014 && identity.ErrCode != 42001 {
+			break
+		}
+	}
+	if identity.ErrCode != 0 {
+		return &model.OPTokenResponse{Error: "invalid_grant", ErrorDescription: fmt.Sprintf("%d: %s", identity.ErrCode, identity.ErrMsg)}, nil
+	}
+	if identity.UserID == "" {
+		return &model.OPTokenResponse{Error: "access_denied", ErrorDescription: "user is not a member of the corp"}, nil
+	}
+
+	// 2. 保存成员身份，RP 以返回的令牌访问 /userinfo
+	token, err := NewRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(map[string]string{"userid": identity.UserID})
+	if err != nil {
+		return nil, err
+	}
+	if err := GlobalStore.Set(weComUserKey(token), string(data), weComUserTTL); err != nil {
+		return nil, err
+	}
+	return &model.OPTokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(weComUserTTL.Seconds())}, nil
+}
+
+// weComUserInfo 读取 weComTokenRequest 保存的成员身份
+func weComUserInfo(accessToken string) (map[string]interface{}, error) {
+	value, err := GlobalStore.Get(weComUserKey(accessToken))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("invalid or expired access token")
+	} else if err != nil {
+		return nil, err
+	}
+	var userInfo map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &userInfo); err != nil {
+		return nil, err
+	}
+	return userInfo, nil
+}
+
+// weComUserKey 以令牌的摘要作为缓存键，避免在缓存中保存明文令牌
+func weComUserKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "wecom_user:" + hex.EncodeToString(sum[:])
+}
+
+// weComAccessToken 返回企业微信应用的 access_token，refresh 为 true 时忽略缓存重新获取
+func weComAccessToken(cfg *model.Config, corpID, secret string, refresh bool) (string, error) {
+	key := corpID + "\x00" + secret
+	weComTokens.Lock()
+	defer weComTokens.Unlock()
+	if cached, ok := weComTokens.tokens[key]; ok && !refresh && time.Now().Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	query := url.Values{}
+	query.Set("corpid", corpID)
+	query.Set("corpsecret", secret)
+	req, err := http.NewRequest("GET", cfg.OPTokenURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create OP token request: %v", err)
+	}
+	var result struct {
+		weComError
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSONRequest(req, &result); err != nil {
+		return "", fmt.Errorf("failed to request OP token endpoint: %v", err)
+	}
+	if result.ErrCode != 0 || result.AccessToken == "" {
+		return "", fmt.Errorf("failed to get WeCom app access token: %d: %s", result.ErrCode, result.ErrMsg)
+	}
+
+	weComTokens.tokens[key] = weComAppToken{
+		token:     result.AccessToken,
+		expiresAt: time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - weComTokenExpiryMargin),
+	}
+	utils.DebugLogger.Printf("Fetched WeCom app access token for corp: %s", corpID)
+	return result.AccessToken, nil
+}
+
+// doJSONRequest 发送请求并将 JSON 响应解析到 out，非 2xx 响应中的错误信息同样会被解析
+func doJSONRequest(req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response with status %d: %v", resp.StatusCode, err)
+	}
+	return nil
+}
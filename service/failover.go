@@ -0,0 +1,250 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"oidc-bridge/utils"
+)
+
+// ErrStoreUnavailable 表示共享存储不可用且未配置回退，请求应以失败结束而不是使用本地数据
+var ErrStoreUnavailable = errors.New("store: unavailable")
+
+const (
+	// StoreFailureFail Redis 不可达时拒绝启动，运行中断开时请求失败直到重连成功
+	StoreFailureFail = "fail"
+	// StoreFailureFallback Redis 不可达时使用本进程内存，重连成功后切回 Redis（默认，与之前的行为一致）
+	StoreFailureFallback = "fallback"
+	// StoreFailureDegrade Redis 不可达时照常启动，依赖存储的请求失败直到重连成功
+	StoreFailureDegrade = "degrade"
+)
+
+const (
+	defaultReconnectInterval    = time.Second
+	defaultMaxReconnectInterval = 30 * time.Second
+)
+
+// FailoverStore 包装共享存储，在主存储不可用时按策略回退到本地存储或返回 ErrStoreUnavailable，
+// 并在后台按指数退避间隔重连，重连成功后切回主存储
+type FailoverStore struct {
+	primary     Store
+	ping        func() error
+	fallback    Store
+	minInterval time.Duration
+	maxInterval time.Duration
+
+	mutex        sync.Mutex
+	healthy      bool
+	lastError    error
+	since        time.Time
+	reconnecting bool
+	stop         chan struct{}
+}
+
+// NewFailoverStore 创建故障切换存储，fallback 为 nil 时主存储不可用期间返回 ErrStoreUnavailable
+// ping 用于检测主存储是否可用，重连间隔从 minInterval 开始翻倍，不超过 maxInterval
+func NewFailoverStore(primary Store, ping func() error, fallback Store, minInterval, maxInterval time.Duration) *FailoverStore {
+	if minInterval <= 0 {
+		minInterval = defaultReconnectInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	return &FailoverStore{
+		primary:     primary,
+		ping:        ping,
+		fallback:    fallback,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		healthy:     true,
+		since:       time.Now(),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Primary 返回主存储，主存储不可用时返回 ErrStoreUnavailable，不会使用回退存储
+func (s *FailoverStore) Primary() (Store, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.healthy {
+		return nil, ErrStoreUnavailable
+	}
+	return s.primary, nil
+}
+
+// Close 停止后台重连
+func (s *FailoverStore) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
+
+// MarkUnavailable 将主存储标记为不可用并开始后台重连
+func (s *FailoverStore) MarkUnavailable(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastError = err
+	if s.healthy {
+		s.healthy = false
+		s.since = time.Now()
+		if s.fallback != nil {
+			utils.ErrorLogger.Printf("Store unavailable, using local memory until it recovers: %v", err)
+		} else {
+			utils.ErrorLogger.Printf("Store unavailable, requests will fail until it recovers: %v", err)
+		}
+	}
+	if !s.reconnecting {
+		s.reconnecting = true
+		go s.reconnectLoop()
+	}
+}
+
+func (s *FailoverStore) reconnectLoop() {
+	interval := s.minInterval
+	for {
+		select {
+		case <-time.After(interval):
+		case <-s.stop:
+			return
+		}
+
+		err := s.ping()
+		s.mutex.Lock()
+		if err == nil {
+			s.healthy = true
+			s.lastError = nil
+			s.since = time.Now()
+			s.reconnecting = false
+			s.mutex.Unlock()
+			if s.fallback != nil {
+				utils.InfoLogger.Println("Store reconnected, switching back from local memory; data written during the outage is not migrated")
+			} else {
+				utils.InfoLogger.Println("Store reconnected")
+			}
+			return
+		}
+		s.lastError = err
+		s.mutex.Unlock()
+
+		utils.DebugLogger.Printf("Store reconnect failed, retrying in %s: %v", interval, err)
+		interval *= 2
+		if interval > s.maxInterval {
+			interval = s.maxInterval
+		}
+	}
+}
+
+// current 返回当前应使用的存储
+func (s *FailoverStore) current() (Store, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.healthy {
+		return s.primary, true, nil
+	}
+	if s.fallback != nil {
+		return s.fallback, false, nil
+	}
+	return nil, false, ErrStoreUnavailable
+}
+
+// do 在当前存储上执行操作，主存储出错且 ping 失败时切换状态并在回退存储上重试
+func (s *FailoverStore) do(op func(store Store) error) error {
+	store, primary, err := s.current()
+	if err != nil {
+		return err
+	}
+
+	err = op(store)
+	if !primary || err == nil || errors.Is(err, ErrNotFound) {
+		return err
+	}
+	// 区分连接故障和单次操作错误
+	if pingErr := s.ping(); pingErr == nil {
+		return err
+	}
+	s.MarkUnavailable(err)
+
+	if s.fallback == nil {
+		return ErrStoreUnavailable
+	}
+	return op(s.fallback)
+}
+
+func (s *FailoverStore) Set(key, value string, ttl time.Duration) error {
+	return s.do(func(store Store) error {
+		return store.Set(key, value, ttl)
+	})
+}
+
+func (s *FailoverStore) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	var created bool
+	err := s.do(func(store Store) (err error) {
+		created, err = store.SetNX(key, value, ttl)
+		return err
+	})
+	return created, err
+}
+
+func (s *FailoverStore) Get(key string) (string, error) {
+	var value string
+	err := s.do(func(store Store) (err error) {
+		value, err = store.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *FailoverStore) GetDel(key string) (string, error) {
+	var value string
+	err := s.do(func(store Store) (err error) {
+		value, err = store.GetDel(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *FailoverStore) Delete(key string) error {
+	return s.do(func(store Store) error {
+		return store.Delete(key)
+	})
+}
+
+func (s *FailoverStore) Incr(key string, ttl time.Duration) (int64, error) {
+	var value int64
+	err := s.do(func(store Store) (err error) {
+		value, err = store.Incr(key, ttl)
+		return err
+	})
+	return value, err
+}
+
+// Status 返回主存储的健康状态
+func (s *FailoverStore) Status() StoreStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := StoreStatus{Healthy: s.healthy, Mode: StoreModePrimary, Since: s.since.UTC().Format(time.RFC3339)}
+	if !s.healthy {
+		status.Mode = StoreModeUnavailable
+		if s.fallback != nil {
+			status.Mode = StoreModeFallback
+		}
+	}
+	if s.lastError != nil {
+		status.Error = s.lastError.Error()
+	}
+	if memory, ok := s.fallback.(*MemoryStore); ok && !s.healthy {
+		stats := memory.Stats()
+		status.Memory = &stats
+	}
+	return status
+}
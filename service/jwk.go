@@ -0,0 +1,156 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"oidc-bridge/model"
+)
+
+// PublicJWK 将公钥转换为 JWK，kid 取 RFC 7638 指纹
+func PublicJWK(publicKey crypto.PublicKey, alg string) (model.JWK, error) {
+	jwk := model.JWK{
+		Use: "sig",
+		Alg: alg,
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		// n 和 e 均为大端序无符号整数的 Base64 URL 编码 (RFC 7518 6.3.1)
+		jwk.KTY = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// 未压缩点格式为 0x04 || X || Y，坐标长度与曲线长度一致 (RFC 7518 6.2.1.2)
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return model.JWK{}, err
+		}
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+
+		jwk.KTY = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	case ed25519.PublicKey:
+		jwk.KTY = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return model.JWK{}, fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	kid, err := JWKThumbprint(jwk)
+	if err != nil {
+		return model.JWK{}, err
+	}
+	jwk.Kid = kid
+	return jwk, nil
+}
+
+// JWKThumbprint 计算 JWK 的 RFC 7638 SHA-256 指纹
+// 只取密钥类型对应的必需成员，按字典序序列化且不含空白
+func JWKThumbprint(jwk model.JWK) (string, error) {
+	var members interface{}
+	switch jwk.KTY {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			KTY string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KTY, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			KTY string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.KTY, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			KTY string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.KTY, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", jwk.KTY)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// KeyID 返回公钥的 kid，与 JWKS 端点发布的 kid 一致
+func KeyID(publicKey crypto.PublicKey) (string, error) {
+	jwk, err := PublicJWK(publicKey, "")
+	if err != nil {
+		return "", err
+	}
+	return jwk.Kid, nil
+}
+
+// PublicKeyFromJWK 将 JWK 转换为公钥，用于校验 RP 以 private_key_jwt 签名的客户端断言
+func PublicKeyFromJWK(jwk model.JWK) (crypto.PublicKey, error) {
+	switch jwk.KTY {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA JWK: %w", err)
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA JWK exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve ecdh.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = ecdh.P256()
+		case "P-384":
+			curve = ecdh.P384()
+		default:
+			return nil, fmt.Errorf("unsupported EC JWK curve: %s", jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid EC JWK coordinates")
+		}
+
+		// 借助 ecdh 校验点在曲线上，再经 PKIX 编码转换为 ecdsa 公钥
+		point := append([]byte{0x04}, append(x, y...)...)
+		key, err := curve.NewPublicKey(point)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC JWK: %w", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParsePKIXPublicKey(der)
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 JWK")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type: %s", jwk.KTY)
+	}
+}
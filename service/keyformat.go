@@ -0,0 +1,360 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 加密 PKCS#8 (RFC 5958) 使用的算法标识，支持 OpenSSL 默认的 PBES2 + PBKDF2 + AES-CBC
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// PBKDF2 参数的上限，私钥文件可能来自不受信任的来源，过大的迭代次数会让启动时的密钥派生长时间占用 CPU
+// OpenSSL 默认使用 2048 次迭代和 8 字节的盐，上限留有足够余量
+const (
+	maxPBKDF2Iterations = 10000000
+	minPBKDF2SaltLen    = 8
+	maxPBKDF2SaltLen    = 64
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 解密 "ENCRYPTED PRIVATE KEY"，返回未加密的 PKCS#8 DER
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid encrypted private key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption: %v", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+
+	// 1. 确定对称加密算法和密钥长度
+	var keyLen int
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen = 16
+	case scheme.Equal(oidAES192CBC):
+		keyLen = 24
+	case scheme.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported private key cipher: %v", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid private key cipher IV")
+	}
+
+	// 2. 使用 PBKDF2 从口令派生密钥
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported private key KDF: %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %w", err)
+	}
+	if kdf.IterationCount < 1 || kdf.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("unsupported PBKDF2 iteration count %d, must be between 1 and %d", kdf.IterationCount, maxPBKDF2Iterations)
+	}
+	if len(kdf.Salt) < minPBKDF2SaltLen || len(kdf.Salt) > maxPBKDF2SaltLen {
+		return nil, fmt.Errorf("unsupported PBKDF2 salt length %d, must be between %d and %d bytes", len(kdf.Salt), minPBKDF2SaltLen, maxPBKDF2SaltLen)
+	}
+	if kdf.KeyLength != 0 && kdf.KeyLength != keyLen {
+		return nil, fmt.Errorf("PBKDF2 key length %d does not match the cipher", kdf.KeyLength)
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF: %v", kdf.PRF.Algorithm)
+	}
+	key := pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLen, prf)
+
+	// 3. AES-CBC 解密并去除 PKCS#7 填充，填充错误通常意味着口令不正确
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted private key length")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("failed to decrypt private key: incorrect passphrase")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// encryptPKCS8Iterations 加密私钥时的 PBKDF2-HMAC-SHA256 迭代次数
+const encryptPKCS8Iterations = 600000
+
+// encryptPKCS8 以 PBES2 + PBKDF2-HMAC-SHA256 + AES-256-CBC 加密 PKCS#8 DER，与 openssl pkcs8 -topk8 -v2 aes-256-cbc 的输出格式相同
+func encryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	// 1. 派生密钥并以 PKCS#7 填充后加密
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, encryptPKCS8Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(append([]byte{}, der...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	// 2. 编码算法参数
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: encryptPKCS8Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
+
+// privateJWK 私钥 JWK 中用到的成员 (RFC 7518 6.2.2、6.3.2，RFC 8037)
+type privateJWK struct {
+	KTY string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	D   string `json:"d"`
+	P   string `json:"p"`
+	Q   string `json:"q"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// isJWKData 判断密钥文件是否为 JSON 格式的 JWK 或 JWKS
+func isJWKData(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// isPublicKeyData 判断文件内容是否为公钥或证书（PEM，或不含私钥参数 d 的 JWK/JWKS）
+func isPublicKeyData(data []byte) bool {
+	if isJWKData(data) {
+		var jwk struct {
+			D    string `json:"d"`
+			Keys []struct {
+				D string `json:"d"`
+			} `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return false
+		}
+		if len(jwk.Keys) > 0 {
+			return jwk.Keys[0].D == ""
+		}
+		return jwk.Keys == nil && jwk.D == ""
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	switch block.Type {
+	case "PUBLIC KEY", "RSA PUBLIC KEY", "CERTIFICATE":
+		return true
+	}
+	return false
+}
+
+// parsePrivateJWK 解析 JWK 或 JWKS 文件中的私钥，JWKS 中取第一个密钥
+func parsePrivateJWK(data []byte) (crypto.Signer, error) {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	if set.Keys != nil {
+		if len(set.Keys) == 0 {
+			return nil, errors.New("JWKS contains no keys")
+		}
+		data = set.Keys[0]
+	}
+
+	var jwk privateJWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	if jwk.D == "" {
+		return nil, errors.New("JWK does not contain a private key")
+	}
+
+	var signer crypto.Signer
+	var err error
+	switch jwk.KTY {
+	case "RSA":
+		signer, err = rsaKeyFromJWK(jwk)
+	case "EC":
+		signer, err = ecKeyFromJWK(jwk)
+	case "OKP":
+		signer, err = okpKeyFromJWK(jwk)
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type: %s", jwk.KTY)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 由私钥推导的公钥必须与 JWK 中的公钥成员一致
+	derived, err := PublicJWK(signer.Public(), "")
+	if err != nil {
+		return nil, err
+	}
+	if derived.N != jwk.N || derived.E != jwk.E || derived.X != jwk.X || derived.Y != jwk.Y {
+		return nil, errors.New("JWK public parameters do not match private key")
+	}
+	return signer, nil
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func rsaKeyFromJWK(jwk privateJWK) (crypto.Signer, error) {
+	var values [5]*big.Int
+	for i, member := range []string{jwk.N, jwk.E, jwk.D, jwk.P, jwk.Q} {
+		value, err := decodeJWKInt(member)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA JWK: %w", err)
+		}
+		values[i] = value
+	}
+	if !values[1].IsInt64() {
+		return nil, errors.New("invalid RSA JWK exponent")
+	}
+
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: values[0], E: int(values[1].Int64())},
+		D:         values[2],
+		Primes:    []*big.Int{values[3], values[4]},
+	}
+	if err := key.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid RSA JWK: %w", err)
+	}
+	key.Precompute()
+	return key, nil
+}
+
+func ecKeyFromJWK(jwk privateJWK) (crypto.Signer, error) {
+	var curve ecdh.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = ecdh.P256()
+	case "P-384":
+		curve = ecdh.P384()
+	default:
+		return nil, fmt.Errorf("unsupported EC JWK curve: %s", jwk.Crv)
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(jwk.D)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EC JWK: %w", err)
+	}
+	key, err := curve.NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EC JWK: %w", err)
+	}
+
+	// 借助 PKCS#8 编码将 ecdh 私钥转换为 ecdsa 私钥
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return parsePKCS8PrivateKey(der)
+}
+
+func okpKeyFromJWK(jwk privateJWK) (crypto.Signer, error) {
+	if jwk.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported OKP JWK curve: %s", jwk.Crv)
+	}
+
+	seed, err := base64.RawURLEncoding.DecodeString(jwk.D)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid Ed25519 JWK private key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+)
+
+const (
+	// KeyStorageFile 签名私钥保存在 private_key_path 指定的文件中（默认）
+	KeyStorageFile = "file"
+	// KeyStorageStore 签名私钥保存在共享存储（GlobalStore）中，所有副本使用同一密钥
+	// 存储需为 Redis 或 PostgreSQL，配置了私钥口令时以加密 PKCS#8 保存
+	KeyStorageStore = "store"
+	// KeyStorageRedis KeyStorageStore 的旧名称，保留以兼容已有配置
+	KeyStorageRedis = "redis"
+)
+
+// signingKeyStoreKey 存储中保存签名私钥 PEM 的键，各提供方的私钥分别保存
+func signingKeyStoreKey(cfg *model.Config) string {
+	if cfg.Name != "" {
+		return "signing_key:" + cfg.Name
+	}
+	return "signing_key"
+}
+
+// GenerateSigningKey 生成与签名算法匹配的私钥
+func GenerateSigningKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+}
+
+// encodeStoredPrivateKeyPEM 编码保存到共享存储的私钥，配置了口令时加密，避免能读取存储的人直接取得签名私钥
+func encodeStoredPrivateKeyPEM(cfg *model.Config, signer crypto.Signer) ([]byte, error) {
+	passphrase, err := configuredPassphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == nil {
+		return encodePrivateKeyPEM(signer)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptPKCS8(der, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}), nil
+}
+
+func encodePrivateKeyPEM(signer crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func encodePublicKeyPEM(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// usesStoredSigningKey 签名私钥是否保存在共享存储中
+func usesStoredSigningKey(cfg *model.Config) bool {
+	return cfg.SigningKeyStorage == KeyStorageStore || cfg.SigningKeyStorage == KeyStorageRedis
+}
+
+// loadStoredPrivateKey 从共享存储中加载签名私钥
+func loadStoredPrivateKey(cfg *model.Config) (crypto.Signer, error) {
+	store, err := signingKeyStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	value, err := store.Get(signingKeyStoreKey(cfg))
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.New("signing key not found in store")
+	} else if err != nil {
+		return nil, err
+	}
+	return parsePrivateKeyPEM(cfg, []byte(value))
+}
+
+// EnsureSigningKey 启动时检查每个提供方的签名密钥，不存在且开启 auto_generate_key 时生成并持久化
+func EnsureSigningKey() error {
+	return ensureSigningKeys(config.Current())
+}
+
+// ensureSigningKeys 检查 cfg 中每个提供方的签名密钥
+func ensureSigningKeys(cfg *model.Config) error {
+	for _, provider := range config.ProviderConfigs(cfg) {
+		if err := ensureSigningKey(provider); err != nil {
+			if provider.Name != "" {
+				return fmt.Errorf("provider %s: %w", provider.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureSigningKey(cfg *model.Config) error {
+	// 密钥集由运维方管理，不自动生成
+	if keySetConfigured(cfg) {
+		return nil
+	}
+
+	var signer crypto.Signer
+	var err error
+	if usesStoredSigningKey(cfg) {
+		signer, err = ensureStoredSigningKey(cfg)
+	} else {
+		signer, err = ensureSigningKeyFile(cfg)
+	}
+	if err != nil || signer == nil {
+		return err
+	}
+
+	kid, err := KeyID(signer.Public())
+	if err != nil {
+		return err
+	}
+	utils.InfoLogger.Printf("Using signing key with kid: %s", kid)
+	return nil
+}
+
+// signingKeyStore 返回保存签名私钥的共享存储
+// 内存和 SQLite 存储以及故障切换后的本地内存不在副本间共享，每个副本会生成各自的密钥且主存储恢复后丢失，因此报错
+func signingKeyStore(cfg *model.Config) (Store, error) {
+	store, err := sharedStore()
+	if err != nil {
+		return nil, fmt.Errorf("signing_key_storage %s requires a reachable shared store: %w", cfg.SigningKeyStorage, err)
+	}
+	return store, nil
+}
+
+// ensureStoredSigningKey 多个副本同时启动时只有一个能写入，其余副本读取已写入的密钥
+func ensureStoredSigningKey(cfg *model.Config) (crypto.Signer, error) {
+	store, err := signingKeyStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.Get(signingKeyStoreKey(cfg)); errors.Is(err, ErrNotFound) {
+		if !cfg.AutoGenerateKey {
+			return nil, errors.New("signing key not found in store and auto_generate_key is disabled")
+		}
+
+		signer, err := GenerateSigningKey(SigningAlg(cfg))
+		if err != nil {
+			return nil, err
+		}
+		data, err := encodeStoredPrivateKeyPEM(cfg, signer)
+		if err != nil {
+			return nil, err
+		}
+		created, err := store.SetNX(signingKeyStoreKey(cfg), string(data), 0)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			utils.InfoLogger.Println("Generated signing key and saved it to the shared store")
+			if !bytes.Contains(data, []byte("ENCRYPTED")) {
+				utils.InfoLogger.Println("The stored signing key is not encrypted, set private_key_passphrase_file to protect it from anyone who can read the store")
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return loadStoredPrivateKey(cfg)
+}
+
+// ensureSigningKeyFile 私钥文件不存在时生成密钥对并写入 private_key_path 和 public_key_path
+func ensureSigningKeyFile(cfg *model.Config) (crypto.Signer, error) {
+	path := cfg.PrivateKeyPath
+	if _, err := os.Stat(path); err == nil {
+		return LoadPrivateKey(cfg)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !cfg.AutoGenerateKey {
+		// 由密钥管理器在加载时报告缺少密钥
+		return nil, nil
+	}
+
+	signer, err := GenerateSigningKey(SigningAlg(cfg))
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodePrivateKeyPEM(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// 硬链接不会覆盖已有文件，共享卷上并发启动的副本只有一个能创建私钥，其余副本读取到的总是完整的密钥
+	if err := writeFileAtomic(path, data, 0600, false); os.IsExist(err) {
+		return LoadPrivateKey(cfg)
+	} else if err != nil {
+		return nil, err
+	}
+	utils.InfoLogger.Printf("Generated signing key at %s", path)
+
+	if cfg.PublicKeyPath != "" {
+		publicData, err := encodePublicKeyPEM(signer.Public())
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(cfg.PublicKeyPath, publicData, 0644, true); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
+
+// writeFileAtomic 先将 data 写入同一目录下的临时文件，再放到 path，path 只会是不存在或内容完整
+// replace 为 false 时通过硬链接放置，path 已存在时返回满足 os.IsExist 的错误；为 true 时通过重命名覆盖
+func writeFileAtomic(path string, data []byte, perm os.FileMode, replace bool) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if replace {
+		return os.Rename(tmpPath, path)
+	}
+	return os.Link(tmpPath, path)
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// KeyManager 在内存中缓存一个提供方解析后的签名密钥，密钥文件变化时自动重新加载
+type KeyManager struct {
+	cfg     *model.Config
+	mutex   sync.RWMutex
+	keys    []SigningKey
+	watcher *fsnotify.Watcher
+}
+
+// keyManagers 按提供方名称保存的密钥管理器，顶层配置的名称为空；重新加载配置时整体替换
+var (
+	keyManagers      map[string]*KeyManager
+	keyManagersMutex sync.RWMutex
+)
+
+// InitKeyManager 启动时加载并校验所有提供方的签名密钥，失败时返回错误以便在启动阶段暴露配置问题
+func InitKeyManager() error {
+	managers, err := newKeyManagers(config.Current())
+	if err != nil {
+		return err
+	}
+	replaceKeyManagers(managers)
+	return nil
+}
+
+// newKeyManagers 为 cfg 中的每个提供方加载签名密钥并监听密钥文件，任一提供方失败时停止已创建的管理器
+func newKeyManagers(cfg *model.Config) (map[string]*KeyManager, error) {
+	managers := make(map[string]*KeyManager)
+	for _, provider := range config.ProviderConfigs(cfg) {
+		manager := &KeyManager{cfg: provider}
+		err := manager.Reload()
+		if err == nil {
+			err = manager.watch()
+		}
+		if err != nil {
+			for _, started := range managers {
+				started.Close()
+			}
+			if provider.Name != "" {
+				return nil, fmt.Errorf("provider %s: %w", provider.Name, err)
+			}
+			return nil, err
+		}
+		managers[provider.Name] = manager
+	}
+	return managers, nil
+}
+
+// replaceKeyManagers 替换密钥管理器，并停止监听原有管理器的密钥文件
+func replaceKeyManagers(managers map[string]*KeyManager) {
+	keyManagersMutex.Lock()
+	previous := keyManagers
+	keyManagers = managers
+	keyManagersMutex.Unlock()
+
+	for _, manager := range previous {
+		manager.Close()
+	}
+}
+
+// CheckSigningKeys 检查 cfg 中每个提供方的签名密钥能否加载、是否与签名算法匹配，不监听密钥文件
+// 保存在共享存储中的密钥需要连接存储，不在此检查；开启 auto_generate_key 时尚不存在的密钥文件会在启动时生成
+func CheckSigningKeys(cfg *model.Config) error {
+	var errs []error
+	for _, provider := range config.ProviderConfigs(cfg) {
+		if !keySetConfigured(provider) {
+			if usesStoredSigningKey(provider) {
+				continue
+			}
+			if _, err := os.Stat(provider.PrivateKeyPath); os.IsNotExist(err) && provider.AutoGenerateKey {
+				continue
+			}
+		}
+
+		keys, err := loadConfiguredKeys(provider)
+		if err == nil {
+			err = validateSigningKeys(provider, keys)
+		}
+		if err != nil {
+			if provider.Name != "" {
+				err = fmt.Errorf("provider %s: %w", provider.Name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CloseKeyManager 停止监听密钥文件并丢弃缓存的密钥
+func CloseKeyManager() {
+	replaceKeyManagers(nil)
+}
+
+// Close 停止监听密钥文件
+func (m *KeyManager) Close() {
+	if m.watcher != nil {
+		m.watcher.Close()
+	}
+}
+
+// Reload 重新加载签名密钥，校验失败时保留原有密钥
+func (m *KeyManager) Reload() error {
+	keys, err := loadConfiguredKeys(m.cfg)
+	if err != nil {
+		return err
+	}
+	if err := validateSigningKeys(m.cfg, keys); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.keys = keys
+	m.mutex.Unlock()
+
+	for _, key := range keys {
+		if kid, err := KeyID(key.Signer.Public()); err == nil {
+			utils.InfoLogger.Printf("Loaded signing key with kid: %s", kid)
+		}
+	}
+	return nil
+}
+
+// Keys 返回当前缓存的签名密钥
+func (m *KeyManager) Keys() []SigningKey {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.keys
+}
+
+// currentSigningKeys 返回提供方的签名密钥；未初始化密钥管理器时（如单元测试）直接从磁盘加载
+func currentSigningKeys(cfg *model.Config) ([]SigningKey, error) {
+	keyManagersMutex.RLock()
+	manager, ok := keyManagers[cfg.Name]
+	keyManagersMutex.RUnlock()
+	if ok {
+		return manager.Keys(), nil
+	}
+	return loadConfiguredKeys(cfg)
+}
+
+// loadConfiguredKeys 按配置加载密钥集、共享存储中的密钥或 private_key_path 单密钥
+func loadConfiguredKeys(cfg *model.Config) ([]SigningKey, error) {
+	if keySetConfigured(cfg) {
+		return LoadSigningKeys(cfg)
+	}
+
+	signer, err := LoadPrivateKey(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key: %w", err)
+	}
+
+	// 公钥由私钥推导；同时配置了 public_key_path 时检查二者是否匹配
+	if !usesStoredSigningKey(cfg) && cfg.PublicKeyPath != "" {
+		publicKey, err := LoadPublicKey(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load public key: %w", err)
+		}
+		if !publicKeyEqual(signer.Public(), publicKey) {
+			return nil, errors.New("public key does not match private key")
+		}
+	}
+	return []SigningKey{{Signer: signer}}, nil
+}
+
+// validateSigningKeys 检查密钥集非空且每个密钥都与签名算法匹配
+func validateSigningKeys(cfg *model.Config, keys []SigningKey) error {
+	if len(keys) == 0 {
+		return errors.New("no signing keys configured")
+	}
+
+	alg := SigningAlg(cfg)
+	if _, err := SigningMethod(alg); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := CheckKeyAlg(key.Signer.Public(), alg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(x crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// watchedPaths 返回需要监听的密钥文件，存储在 Redis 中的密钥无需监听
+func watchedPaths(cfg *model.Config) []string {
+	if cfg.SigningKeysDir != "" {
+		return nil
+	}
+	if len(cfg.SigningKeys) > 0 {
+		paths := make([]string, 0, len(cfg.SigningKeys))
+		for _, keyConfig := range cfg.SigningKeys {
+			paths = append(paths, keyConfig.PrivateKeyPath)
+		}
+		return paths
+	}
+	if usesStoredSigningKey(cfg) {
+		return nil
+	}
+
+	paths := []string{cfg.PrivateKeyPath}
+	if cfg.PublicKeyPath != "" {
+		paths = append(paths, cfg.PublicKeyPath)
+	}
+	return paths
+}
+
+// watch 监听密钥文件所在目录，文件通过替换或符号链接更新（如 Kubernetes Secret）时也能感知
+func (m *KeyManager) watch() error {
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	if dir := m.cfg.SigningKeysDir; dir != "" {
+		dirs[filepath.Clean(dir)] = true
+	}
+	for _, path := range watchedPaths(m.cfg) {
+		files[filepath.Clean(path)] = true
+		dirs[filepath.Dir(filepath.Clean(path))] = true
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	m.watcher = watcher
+
+	keysDir := m.cfg.SigningKeysDir
+	if keysDir != "" {
+		keysDir = filepath.Clean(keysDir)
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Kubernetes 挂载的 Secret 通过替换 ..data 符号链接更新
+				path := filepath.Clean(event.Name)
+				if !files[path] && (keysDir == "" || filepath.Dir(path) != keysDir) && filepath.Base(path) != "..data" {
+					continue
+				}
+				utils.InfoLogger.Printf("Signing key file changed: %s, reloading", event.Name)
+				if err := m.Reload(); err != nil {
+					utils.ErrorLogger.Printf("Failed to reload signing keys, keeping previous keys: %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				utils.ErrorLogger.Printf("Signing key watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
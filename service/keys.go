@@ -1,35 +1,236 @@
 package service
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"os"
 
-	"oidc-bridge/config"
+	"oidc-bridge/model"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
-func LoadPrivateKey() (*rsa.PrivateKey, error) {
-	keyData, err := os.ReadFile(config.AppConfig.PrivateKeyPath)
+// DefaultSigningAlg 未配置 id_token_signing_alg 时使用的签名算法
+const DefaultSigningAlg = "RS256"
+
+// SigningAlg 返回配置的 ID Token 签名算法
+func SigningAlg(cfg *model.Config) string {
+	if cfg.SigningAlg != "" {
+		return cfg.SigningAlg
+	}
+	return DefaultSigningAlg
+}
+
+// SigningMethod 根据算法名称获取 JWT 签名方法
+func SigningMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "RS384":
+		return jwt.SigningMethodRS384, nil
+	case "RS512":
+		return jwt.SigningMethodRS512, nil
+	case "PS256":
+		return jwt.SigningMethodPS256, nil
+	case "PS384":
+		return jwt.SigningMethodPS384, nil
+	case "PS512":
+		return jwt.SigningMethodPS512, nil
+	case "ES256":
+		return jwt.SigningMethodES256, nil
+	case "ES384":
+		return jwt.SigningMethodES384, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+}
+
+// CheckKeyAlg 检查公钥类型是否与签名算法匹配
+func CheckKeyAlg(publicKey crypto.PublicKey, alg string) error {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		if _, ok := publicKey.(*rsa.PublicKey); ok {
+			return nil
+		}
+	case "ES256":
+		if key, ok := publicKey.(*ecdsa.PublicKey); ok && key.Curve == elliptic.P256() {
+			return nil
+		}
+	case "ES384":
+		if key, ok := publicKey.(*ecdsa.PublicKey); ok && key.Curve == elliptic.P384() {
+			return nil
+		}
+	case "EdDSA":
+		if _, ok := publicKey.(ed25519.PublicKey); ok {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	return fmt.Errorf("key type %T is not compatible with signing algorithm %s", publicKey, alg)
+}
+
+func readPEMBlock(path string) (*pem.Block, error) {
+	keyData, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
 	block, _ := pem.Decode(keyData)
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
 }
 
-func LoadPublicKey() (*rsa.PublicKey, error) {
-	keyData, err := os.ReadFile(config.AppConfig.PublicKeyPath)
+// LoadPrivateKey 加载签名私钥，支持 PKCS#1、SEC1、PKCS#8（含加密）PEM 以及 JWK/JWKS 文件
+func LoadPrivateKey(cfg *model.Config) (crypto.Signer, error) {
+	if usesStoredSigningKey(cfg) {
+		return loadStoredPrivateKey(cfg)
+	}
+	return loadPrivateKeyFile(cfg, cfg.PrivateKeyPath)
+}
+
+func loadPrivateKeyFile(cfg *model.Config, path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isJWKData(data) {
+		return parsePrivateJWK(data)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return parsePrivateKeyBlock(cfg, block)
+}
+
+// parsePrivateKeyData 解析 PEM 或 JWK 格式的私钥
+func parsePrivateKeyData(cfg *model.Config, data []byte) (crypto.Signer, error) {
+	if isJWKData(data) {
+		return parsePrivateJWK(data)
+	}
+	return parsePrivateKeyPEM(cfg, data)
+}
+
+func parsePrivateKeyPEM(cfg *model.Config, data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return parsePrivateKeyBlock(cfg, block)
+}
+
+func parsePrivateKeyBlock(cfg *model.Config, block *pem.Block) (crypto.Signer, error) {
+	// OpenSSL 传统加密格式（Proc-Type: 4,ENCRYPTED），先解密再按类型解析
+	if x509.IsEncryptedPEMBlock(block) { //nolint:staticcheck // 仅为兼容已有密钥，新密钥应使用加密 PKCS#8
+		passphrase, err := keyPassphrase(cfg)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.DecryptPEMBlock(block, passphrase) //nolint:staticcheck // 同上
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+		block = &pem.Block{Type: block.Type, Bytes: der}
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return parsePKCS8PrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		passphrase, err := keyPassphrase(cfg)
+		if err != nil {
+			return nil, err
+		}
+		der, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return parsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unsupported private key PEM type: %s", block.Type)
+	}
+}
+
+func parsePKCS8PrivateKey(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return signer, nil
+}
+
+// keyPassphrase 读取加密私钥的口令，未配置口令时报错
+func keyPassphrase(cfg *model.Config) ([]byte, error) {
+	passphrase, err := configuredPassphrase(cfg)
+	if err == nil && passphrase == nil {
+		return nil, errors.New("private key is encrypted but no passphrase is configured")
+	}
+	return passphrase, err
+}
+
+// configuredPassphrase 读取私钥口令，环境变量 PRIVATE_KEY_PASSPHRASE 优先于 private_key_passphrase_file，都未配置时返回 nil
+func configuredPassphrase(cfg *model.Config) ([]byte, error) {
+	if passphrase := os.Getenv("PRIVATE_KEY_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if path := cfg.PrivateKeyPassphraseFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key passphrase: %w", err)
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	return nil, nil
+}
+
+func LoadPublicKey(cfg *model.Config) (crypto.PublicKey, error) {
+	// 私钥保存在共享存储中或未配置 public_key_path 时，公钥由私钥推导
+	if usesStoredSigningKey(cfg) || cfg.PublicKeyPath == "" {
+		signer, err := LoadPrivateKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+
+	block, err := readPEMBlock(cfg.PublicKeyPath)
 	if err != nil {
 		return nil, err
 	}
 
-	block, _ := pem.Decode(keyData)
 	key, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	return key.(*rsa.PublicKey), nil
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", key)
+	}
 }
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+)
+
+// SigningKey 密钥集中的一个签名密钥及其生效区间
+// NotBefore 之后开始用于签名，NotAfter 之后停止签名；
+// 停止签名后公钥继续发布 id_token_lifetime 秒（client 单独配置了更长的有效期时取最长值），保证已签发的 ID Token 仍可验证
+type SigningKey struct {
+	Signer    crypto.Signer
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// defaultSigningKeysPrepublish 未配置 signing_keys_prepublish 时，signing_keys_dir 中的新密钥从发布到开始签名的间隔
+const defaultSigningKeysPrepublish = time.Hour
+
+// ErrNoActiveSigningKey 表示密钥集中当前没有处于生效区间的密钥
+var ErrNoActiveSigningKey = errors.New("no active signing key")
+
+// keySetConfigured 是否配置了密钥集；未配置时沿用 private_key_path/public_key_path 单密钥
+func keySetConfigured(cfg *model.Config) bool {
+	return len(cfg.SigningKeys) > 0 || cfg.SigningKeysDir != ""
+}
+
+// LoadSigningKeys 从 signing_keys 或 signing_keys_dir 加载密钥集
+func LoadSigningKeys(cfg *model.Config) ([]SigningKey, error) {
+	if cfg.SigningKeysDir != "" {
+		return loadSigningKeysDir(cfg, cfg.SigningKeysDir)
+	}
+
+	keys := make([]SigningKey, 0, len(cfg.SigningKeys))
+	for _, keyConfig := range cfg.SigningKeys {
+		signer, err := loadPrivateKeyFile(cfg, keyConfig.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key %s: %w", keyConfig.PrivateKeyPath, err)
+		}
+
+		key := SigningKey{Signer: signer}
+		if key.NotBefore, err = parseKeyTime(keyConfig.NotBefore); err != nil {
+			return nil, fmt.Errorf("invalid not_before for signing key %s: %w", keyConfig.PrivateKeyPath, err)
+		}
+		if key.NotAfter, err = parseKeyTime(keyConfig.NotAfter); err != nil {
+			return nil, fmt.Errorf("invalid not_after for signing key %s: %w", keyConfig.PrivateKeyPath, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// signingKeysPrepublish 返回 signing_keys_dir 中新密钥的预发布时长，配置为 0 时使用默认值，小于 0 时不预发布
+func signingKeysPrepublish(cfg *model.Config) time.Duration {
+	switch {
+	case cfg.SigningKeysPrepublish > 0:
+		return time.Duration(cfg.SigningKeysPrepublish) * time.Second
+	case cfg.SigningKeysPrepublish < 0:
+		return 0
+	}
+	return defaultSigningKeysPrepublish
+}
+
+// loadSigningKeysDir 从目录加载密钥集，文件修改时间之后先发布 signing_keys_prepublish 秒再开始签名，
+// 使缓存了 JWKS 的 RP 在新密钥签名前已能获取到它；每个密钥在下一个更新的密钥生效时停止签名
+// 最早的密钥立即生效，首次部署时无需等待预发布
+func loadSigningKeysDir(cfg *model.Config, dir string) ([]SigningKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []SigningKey
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// 目录中可能同时存放公钥，跳过而不影响其他密钥
+		if isPublicKeyData(data) {
+			utils.DebugLogger.Printf("Skipping public key %s in signing keys directory", path)
+			continue
+		}
+		// 其他无法解析的文件不能静默跳过，否则损坏的新密钥会让轮换悄无声息地失效
+		signer, err := parsePrivateKeyData(cfg, data)
+		if err != nil {
+			utils.ErrorLogger.Printf("Invalid signing key %s in signing keys directory: %v", path, err)
+			return nil, fmt.Errorf("failed to load signing key %s: %w", path, err)
+		}
+		keys = append(keys, SigningKey{Signer: signer, NotBefore: info.ModTime().Add(signingKeysPrepublish(cfg))})
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].NotBefore.Before(keys[j].NotBefore) })
+	for i := 0; i+1 < len(keys); i++ {
+		keys[i].NotAfter = keys[i+1].NotBefore
+	}
+	if len(keys) > 0 {
+		keys[0].NotBefore = time.Time{}
+	}
+	return keys, nil
+}
+
+func parseKeyTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// ActiveSigningKey 返回当前用于签名的密钥
+func ActiveSigningKey(cfg *model.Config) (crypto.Signer, error) {
+	keys, err := currentSigningKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return activeKey(keys, time.Now())
+}
+
+// PublishedPublicKeys 返回需要在 JWKS 中发布的公钥
+func PublishedPublicKeys(cfg *model.Config) ([]crypto.PublicKey, error) {
+	keys, err := currentSigningKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return publishedKeys(keys, time.Now(), maxIDTokenLifetime(cfg)), nil
+}
+
+// activeKey 在已生效且未停止签名的密钥中选择生效时间最晚的一个
+func activeKey(keys []SigningKey, now time.Time) (crypto.Signer, error) {
+	var active *SigningKey
+	for i := range keys {
+		key := &keys[i]
+		if now.Before(key.NotBefore) || (!key.NotAfter.IsZero() && !now.Before(key.NotAfter)) {
+			continue
+		}
+		if active == nil || key.NotBefore.After(active.NotBefore) {
+			active = key
+		}
+	}
+	if active == nil {
+		return nil, ErrNoActiveSigningKey
+	}
+	return active.Signer, nil
+}
+
+// publishedKeys 返回尚未生效（提前发布）、正在使用以及停止签名后仍在 ID Token 有效期（retention）内的公钥
+func publishedKeys(keys []SigningKey, now time.Time, retention time.Duration) []crypto.PublicKey {
+	var publicKeys []crypto.PublicKey
+	for _, key := range keys {
+		if !key.NotAfter.IsZero() && !now.Before(key.NotAfter.Add(retention)) {
+			continue
+		}
+		publicKeys = append(publicKeys, key.Signer.Public())
+	}
+	return publicKeys
+}
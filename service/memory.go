@@ -1,87 +1,448 @@
 package service
 
 import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"oidc-bridge/config"
+	"oidc-bridge/utils"
+)
+
+const (
+	// defaultMemoryMaxEntries 未配置 memory.max_entries 时的最大缓存项数
+	defaultMemoryMaxEntries = 100000
+	// defaultMemoryMaxBytes 未配置 memory.max_bytes 时的内存预算
+	defaultMemoryMaxBytes = 64 << 20
+	// defaultMemoryMaxPinnedEntries 未配置 memory.max_pinned_entries 时不会被淘汰的缓存项的最大项数
+	defaultMemoryMaxPinnedEntries = 100000
+	// defaultMemoryMaxPinnedBytes 未配置 memory.max_pinned_bytes 时不会被淘汰的缓存项的内存预算
+	defaultMemoryMaxPinnedBytes = 64 << 20
+	// pinnedSweepInterval 不会被淘汰的缓存项达到上限时，两次清理其中过期项的最小间隔
+	pinnedSweepInterval = time.Second
+	// defaultMemoryCleanupInterval 未配置 memory.cleanup_interval 时清理过期项的间隔
+	defaultMemoryCleanupInterval = 10 * time.Minute
+	// cacheItemOverhead 估算每个缓存项除键和值以外占用的字节数
+	cacheItemOverhead = 96
 )
 
+// pinnedKeyPrefixes 不会被淘汰的缓存项：防重放标记被淘汰后授权码和客户端断言可以再次使用，
+// refresh_token 记录被淘汰后刷新时无法重新签发 ID Token
+var pinnedKeyPrefixes = []string{"redeemed:", "assertion:", "refresh:"}
+
+// ErrStoreFull 表示不会被淘汰的缓存项已达到上限，写入失败而不是淘汰防重放标记
+var ErrStoreFull = errors.New("store: memory limit reached")
+
 // MemoryCache 本地内存缓存
+// 配置了容量限制时，超出最大项数或内存预算后淘汰最近最少使用的缓存项；
+// 永不过期的缓存项（动态注册的 client、签名密钥）和 pinnedKeyPrefixes 中的缓存项不会被淘汰，只在过期后清理，
+// 它们有单独的容量限制，达到上限后写入返回 ErrStoreFull
 type MemoryCache struct {
-	data  map[string]*cacheItem
-	mutex sync.RWMutex
+	data             map[string]*list.Element
+	lru              *list.List // 可淘汰的缓存项，链表头部为最近使用的缓存项
+	pinned           *list.List // 不会被淘汰的缓存项
+	maxEntries       int
+	maxBytes         int64
+	maxPinnedEntries int
+	maxPinnedBytes   int64
+	bytes            int64 // 所有缓存项占用的字节数
+	lruBytes         int64 // 可淘汰的缓存项占用的字节数
+	lastPinnedSweep  time.Time
+	stats            MemoryCacheStats
+	stop             chan struct{}
+	mutex            sync.Mutex
+}
+
+// MemoryCacheStats 内存缓存统计信息
+type MemoryCacheStats struct {
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
 }
 
-// cacheItem 缓存项
+// cacheItem 缓存项，expireTime 为零值时永不过期
 type cacheItem struct {
+	key        string
 	value      string
 	expireTime time.Time
+	pinned     bool
+}
+
+func newCacheItem(key, value string, ttl time.Duration) *cacheItem {
+	item := &cacheItem{key: key, value: value, pinned: ttl <= 0}
+	if ttl > 0 {
+		item.expireTime = time.Now().Add(ttl)
+	}
+	for _, prefix := range pinnedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			item.pinned = true
+		}
+	}
+	return item
+}
+
+func (item *cacheItem) expired() bool {
+	return !item.expireTime.IsZero() && time.Now().After(item.expireTime)
+}
+
+func (item *cacheItem) size() int64 {
+	return int64(len(item.key) + len(item.value) + cacheItemOverhead)
 }
 
-// NewMemoryCache 创建新的内存缓存实例
+// NewMemoryCache 创建不限制容量的内存缓存实例
 func NewMemoryCache() *MemoryCache {
+	return NewBoundedMemoryCache(0, 0)
+}
+
+// LimitPinned 设置不会被淘汰的缓存项的容量限制，maxEntries 和 maxBytes 不大于 0 时不限制
+func (m *MemoryCache) LimitPinned(maxEntries int, maxBytes int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.maxPinnedEntries = maxEntries
+	m.maxPinnedBytes = maxBytes
+}
+
+// NewBoundedMemoryCache 创建限制可淘汰缓存项容量的内存缓存实例，maxEntries 和 maxBytes 不大于 0 时不限制
+func NewBoundedMemoryCache(maxEntries int, maxBytes int64) *MemoryCache {
 	return &MemoryCache{
-		data: make(map[string]*cacheItem),
+		data:       make(map[string]*list.Element),
+		lru:        list.New(),
+		pinned:     list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		stop:       make(chan struct{}),
+	}
+}
+
+// lookup 查找未过期的缓存项并标记为最近使用，过期项会被删除，调用方需持有锁
+func (m *MemoryCache) lookup(key string) (*cacheItem, bool) {
+	element, exists := m.data[key]
+	if !exists {
+		return nil, false
+	}
+	item := element.Value.(*cacheItem)
+	if item.expired() {
+		m.remove(element)
+		m.stats.Expirations++
+		return nil, false
+	}
+	if !item.pinned {
+		m.lru.MoveToFront(element)
+	}
+	return item, true
+}
+
+// store 写入缓存项并按容量限制淘汰，不会被淘汰的缓存项超出上限时返回 ErrStoreFull，调用方需持有锁
+func (m *MemoryCache) store(key, value string, ttl time.Duration) error {
+	item := newCacheItem(key, value, ttl)
+	if item.pinned && !m.pinnedFits(item) {
+		return ErrStoreFull
 	}
+	if element, exists := m.data[key]; exists {
+		m.remove(element)
+	}
+	m.bytes += item.size()
+	if item.pinned {
+		m.data[key] = m.pinned.PushFront(item)
+		return nil
+	}
+	m.data[key] = m.lru.PushFront(item)
+	m.lruBytes += item.size()
+	m.evict()
+	return nil
+}
+
+// pinnedFits 检查写入 item 后不会被淘汰的缓存项是否仍在容量限制内，
+// 超出时先清理其中的过期项，清理至多每 pinnedSweepInterval 执行一次，调用方需持有锁
+func (m *MemoryCache) pinnedFits(item *cacheItem) bool {
+	fits := func() bool {
+		entries, bytes := m.pinned.Len()+1, m.bytes-m.lruBytes+item.size()
+		if element, exists := m.data[item.key]; exists && element.Value.(*cacheItem).pinned {
+			entries--
+			bytes -= element.Value.(*cacheItem).size()
+		}
+		return (m.maxPinnedEntries <= 0 || entries <= m.maxPinnedEntries) && (m.maxPinnedBytes <= 0 || bytes <= m.maxPinnedBytes)
+	}
+	if fits() {
+		return true
+	}
+	if time.Since(m.lastPinnedSweep) < pinnedSweepInterval {
+		return false
+	}
+	m.lastPinnedSweep = time.Now()
+	for element := m.pinned.Back(); element != nil; {
+		prev := element.Prev()
+		if element.Value.(*cacheItem).expired() {
+			m.remove(element)
+			m.stats.Expirations++
+		}
+		element = prev
+	}
+	return fits()
+}
+
+// evict 可淘汰的缓存项超出容量限制时淘汰最近最少使用的缓存项，刚写入的缓存项不会被淘汰
+func (m *MemoryCache) evict() {
+	for m.lru.Len() > 1 &&
+		((m.maxEntries > 0 && m.lru.Len() > m.maxEntries) || (m.maxBytes > 0 && m.lruBytes > m.maxBytes)) {
+		element := m.lru.Back()
+		if element.Value.(*cacheItem).expired() {
+			m.stats.Expirations++
+		} else {
+			m.stats.Evictions++
+		}
+		m.remove(element)
+	}
+}
+
+func (m *MemoryCache) remove(element *list.Element) {
+	item := element.Value.(*cacheItem)
+	if item.pinned {
+		m.pinned.Remove(element)
+	} else {
+		m.lru.Remove(element)
+		m.lruBytes -= item.size()
+	}
+	delete(m.data, item.key)
+	m.bytes -= item.size()
+}
+
+// Set 设置缓存项，ttl 不大于 0 时永不过期（与 Redis 一致）
+func (m *MemoryCache) Set(key, value string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.store(key, value, ttl)
 }
 
-// Set 设置缓存项
-func (m *MemoryCache) Set(key, value string, ttl time.Duration) {
+// SetNX 仅当缓存项不存在或已过期时设置，返回是否设置成功
+func (m *MemoryCache) SetNX(key, value string, ttl time.Duration) (bool, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	m.data[key] = &cacheItem{
-		value:      value,
-		expireTime: time.Now().Add(ttl),
+	if _, exists := m.lookup(key); exists {
+		return false, nil
 	}
+	if err := m.store(key, value, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Get 获取缓存项
+// 读取会调整淘汰顺序并删除过期项，因此与写操作使用同一把锁
 func (m *MemoryCache) Get(key string) (string, bool) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	item, exists := m.data[key]
+	item, exists := m.lookup(key)
 	if !exists {
+		m.stats.Misses++
 		return "", false
 	}
+	m.stats.Hits++
+	return item.value, true
+}
+
+// Take 获取并删除缓存项，保证同一缓存项只能被取出一次
+func (m *MemoryCache) Take(key string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	// 检查是否过期
-	if time.Now().After(item.expireTime) {
-		// 删除过期项
-		delete(m.data, key)
+	item, exists := m.lookup(key)
+	if !exists {
+		m.stats.Misses++
 		return "", false
 	}
-
+	m.stats.Hits++
+	m.remove(m.data[key])
 	return item.value, true
 }
 
+// Delete 删除缓存项
+func (m *MemoryCache) Delete(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element, exists := m.data[key]; exists {
+		m.remove(element)
+	}
+}
+
+// Incr 将计数器加一并返回新值，计数器不存在或已过期时从 1 开始并设置过期时间
+func (m *MemoryCache) Incr(key string, ttl time.Duration) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item, exists := m.lookup(key)
+	if !exists {
+		if err := m.store(key, "1", ttl); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	value, err := strconv.ParseInt(item.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value of %s is not an integer", key)
+	}
+	value++
+	size := item.size()
+	item.value = strconv.FormatInt(value, 10)
+	m.bytes += item.size() - size
+	if !item.pinned {
+		m.lruBytes += item.size() - size
+	}
+	return value, nil
+}
+
 // ClearExpired 清理过期项
 func (m *MemoryCache) ClearExpired() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	now := time.Now()
-	for key, item := range m.data {
-		if now.After(item.expireTime) {
-			delete(m.data, key)
+	for _, items := range []*list.List{m.lru, m.pinned} {
+		for element := items.Back(); element != nil; {
+			prev := element.Prev()
+			if element.Value.(*cacheItem).expired() {
+				m.remove(element)
+				m.stats.Expirations++
+			}
+			element = prev
 		}
 	}
 }
 
-// GlobalMemoryCache 全局内存缓存实例
-var GlobalMemoryCache *MemoryCache
+// Stats 返回缓存统计信息
+func (m *MemoryCache) Stats() MemoryCacheStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-// InitMemoryCache 初始化内存缓存
-func InitMemoryCache() {
-	GlobalMemoryCache = NewMemoryCache()
+	stats := m.stats
+	stats.Entries = len(m.data)
+	stats.Bytes = m.bytes
+	return stats
+}
 
-	// 启动定时清理过期项的goroutine
+// StartJanitor 启动按 interval 定期清理过期项的 goroutine，直到调用 Close
+func (m *MemoryCache) StartJanitor(interval time.Duration) {
 	go func() {
-		ticker := time.NewTicker(10 * time.Minute)
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			GlobalMemoryCache.ClearExpired()
+		for {
+			select {
+			case <-ticker.C:
+				m.ClearExpired()
+				if stats := m.Stats(); stats.Evictions > 0 {
+					utils.DebugLogger.Printf("Memory cache: %d entries, %d bytes, %d evictions", stats.Entries, stats.Bytes, stats.Evictions)
+				}
+			case <-m.stop:
+				return
+			}
 		}
 	}()
 }
+
+// Close 停止后台清理
+func (m *MemoryCache) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+}
+
+// GlobalMemoryCache 全局内存缓存实例
+var GlobalMemoryCache *MemoryCache
+
+// InitMemoryCache 按 memory 配置初始化内存缓存，并将其作为全局存储
+// max_entries、max_bytes、max_pinned_entries、max_pinned_bytes 为 0 时使用默认限制，小于 0 时不限制
+func InitMemoryCache() {
+	maxEntries, maxBytes, interval := defaultMemoryMaxEntries, int64(defaultMemoryMaxBytes), defaultMemoryCleanupInterval
+	maxPinnedEntries, maxPinnedBytes := defaultMemoryMaxPinnedEntries, int64(defaultMemoryMaxPinnedBytes)
+	if cfg := config.Current(); cfg != nil {
+		memoryConfig := cfg.Memory
+		if memoryConfig.MaxEntries != 0 {
+			maxEntries = memoryConfig.MaxEntries
+		}
+		if memoryConfig.MaxBytes != 0 {
+			maxBytes = memoryConfig.MaxBytes
+		}
+		if memoryConfig.MaxPinnedEntries != 0 {
+			maxPinnedEntries = memoryConfig.MaxPinnedEntries
+		}
+		if memoryConfig.MaxPinnedBytes != 0 {
+			maxPinnedBytes = memoryConfig.MaxPinnedBytes
+		}
+		if memoryConfig.CleanupInterval > 0 {
+			interval = time.Duration(memoryConfig.CleanupInterval) * time.Second
+		}
+	}
+
+	// 重新初始化时停止旧缓存的清理 goroutine
+	if GlobalMemoryCache != nil {
+		GlobalMemoryCache.Close()
+	}
+	GlobalMemoryCache = NewBoundedMemoryCache(maxEntries, maxBytes)
+	GlobalMemoryCache.LimitPinned(maxPinnedEntries, maxPinnedBytes)
+	GlobalMemoryCache.StartJanitor(interval)
+	GlobalStore = NewMemoryStore(GlobalMemoryCache)
+}
+
+// MemoryStore 基于本地内存缓存的 Store 实现，数据不在副本之间共享
+type MemoryStore struct {
+	cache *MemoryCache
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore(cache *MemoryCache) *MemoryStore {
+	return &MemoryStore{cache: cache}
+}
+
+// Stats 返回缓存统计信息
+func (s *MemoryStore) Stats() MemoryCacheStats {
+	return s.cache.Stats()
+}
+
+func (s *MemoryStore) Set(key, value string, ttl time.Duration) error {
+	return s.cache.Set(key, value, ttl)
+}
+
+func (s *MemoryStore) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	return s.cache.SetNX(key, value, ttl)
+}
+
+func (s *MemoryStore) Get(key string) (string, error) {
+	if value, exists := s.cache.Get(key); exists {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+func (s *MemoryStore) GetDel(key string) (string, error) {
+	if value, exists := s.cache.Take(key); exists {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.cache.Delete(key)
+	return nil
+}
+
+func (s *MemoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	return s.cache.Incr(key, ttl)
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	PKCEMethodS256  = "S256"
+	PKCEMethodPlain = "plain"
+)
+
+// RFC 7636 4.1/4.2: code_verifier 与 code_challenge 均由 43~128 个非保留字符组成
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// NormalizeCodeChallenge 校验 code_challenge 参数，返回规范化后的 method
+// 未指定 method 时按 RFC 7636 默认为 plain
+func NormalizeCodeChallenge(challenge, method string) (string, error) {
+	if method == "" {
+		method = PKCEMethodPlain
+	}
+	if method != PKCEMethodS256 && method != PKCEMethodPlain {
+		return "", fmt.Errorf("unsupported code_challenge_method: %s", method)
+	}
+	if !pkceValuePattern.MatchString(challenge) {
+		return "", errors.New("invalid code_challenge")
+	}
+	return method, nil
+}
+
+// VerifyCodeChallenge 校验 code_verifier 是否与 code_challenge 匹配
+func VerifyCodeChallenge(challenge, method, verifier string) bool {
+	if !pkceValuePattern.MatchString(verifier) {
+		return false
+	}
+
+	computed := deriveCodeChallenge(method, verifier)
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// deriveCodeChallenge 按 method 由 code_verifier 计算 code_challenge
+func deriveCodeChallenge(method, verifier string) string {
+	if method != PKCEMethodS256 {
+		return verifier
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -5,23 +5,56 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
-	"oidc-bridge/config"
 	"oidc-bridge/model"
 )
 
-func ProxyToOPTokenEndpoint(req model.TokenRequest) (*model.OPTokenResponse, error) {
+func ProxyToOPTokenEndpoint(cfg *model.Config, req model.TokenRequest) (*model.OPTokenResponse, error) {
+	// 不遵循标准 OAuth2 令牌接口的平台由对应的方言处理
+	switch cfg.OPDialect {
+	case DialectDingTalk:
+		return dingTalkTokenRequest(cfg, req)
+	case DialectWeCom:
+		return weComTokenRequest(cfg, req)
+	}
+
 	// 构建请求参数
 	form := url.Values{}
 	form.Add("grant_type", req.GrantType)
-	form.Add("code", req.Code)
-	form.Add("redirect_uri", req.RedirectURI)
-	form.Add("client_id", req.ClientID)
-	form.Add("client_secret", req.ClientSecret)
+	switch req.GrantType {
+	case "refresh_token":
+		form.Add("refresh_token", req.RefreshToken)
+		if mappedScopes, _ := MapScopes(cfg, req.Scope); len(mappedScopes) > 0 {
+			form.Add("scope", strings.Join(mappedScopes, " "))
+		}
+	default:
+		form.Add("code", req.Code)
+		form.Add("redirect_uri", req.RedirectURI)
+		if cfg.OPSupportsPKCE && req.CodeVerifier != "" {
+			form.Add("code_verifier", req.CodeVerifier)
+		}
+	}
+	clientID, clientSecret := opClientCredentials(cfg, req)
+
+	// 按 OP 接受的方式提交客户端凭据
+	if cfg.OPTokenAuthMethod != AuthMethodClientSecretBasic {
+		form.Add("client_id", clientID)
+		form.Add("client_secret", clientSecret)
+	}
+	// 按 op_token_request 模板编码参数并设置请求头
+	httpReq, err := newOPTokenRequest(cfg, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OP token request: %v", err)
+	}
+	if cfg.OPTokenAuthMethod == AuthMethodClientSecretBasic {
+		// client_secret_basic 的用户名和密码需先做表单编码（RFC 6749 2.3.1）
+		httpReq.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
 
 	// 发送 POST 请求
-	resp, err := http.PostForm(config.AppConfig.OPTokenURL, form)
+	resp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request to OP token endpoint: %v", err)
 	}
@@ -36,8 +69,17 @@ func ProxyToOPTokenEndpoint(req model.TokenRequest) (*model.OPTokenResponse, err
 	return &opResp, nil
 }
 
+// opClientCredentials 返回向 OP 提交的客户端凭据，桥接服务持有 OP 应用凭据时以自己的凭据代替 RP 的凭据
+func opClientCredentials(cfg *model.Config, req model.TokenRequest) (string, string) {
+	if TranslatesClientCredentials(cfg) {
+		return cfg.OPClientID, cfg.OPClientSecret
+	}
+	return req.ClientID, req.ClientSecret
+}
+
 // GetNestedValue 从嵌套的 map 中获取值
 // 支持两种分隔符：点号(.)和双冒号(::)
+// 配置加载时属性映射的键会被转为小写，因此找不到完全匹配的键时按不区分大小写匹配
 func GetNestedValue(data map[string]interface{}, path string) (interface{}, bool) {
 	// 首先尝试使用双冒号分隔符分割路径
 	parts := strings.Split(path, "::")
@@ -52,15 +94,13 @@ func GetNestedValue(data map[string]interface{}, path string) (interface{}, bool
 	// 遍历路径的每个部分
 	for i, part := range parts {
 		// 如果是最后一部分，直接返回值
+		value, ok := lookupKey(current, part)
 		if i == len(parts)-1 {
-			if value, ok := current[part]; ok {
-				return value, true
-			}
-			return nil, false
+			return value, ok
 		}
 
 		// 如果不是最后一部分，确保当前值是一个 map
-		if next, ok := current[part].(map[string]interface{}); ok {
+		if next, ok := value.(map[string]interface{}); ok {
 			current = next
 		} else {
 			return nil, false
@@ -70,15 +110,68 @@ func GetNestedValue(data map[string]interface{}, path string) (interface{}, bool
 	return nil, false
 }
 
-func GetUserInfoFromOP(accessToken string) (map[string]interface{}, error) {
+// lookupKey 获取 map 中的值，没有完全匹配的键时按不区分大小写匹配
+func lookupKey(data map[string]interface{}, key string) (interface{}, bool) {
+	if value, ok := data[key]; ok {
+		return value, true
+	}
+	for existing, value := range data {
+		if strings.EqualFold(existing, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func GetUserInfoFromOP(cfg *model.Config, accessToken string) (map[string]interface{}, error) {
+	// 获取 OP 返回的原始用户信息
+	var userInfo map[string]interface{}
+	var err error
+	switch cfg.OPDialect {
+	case DialectDingTalk:
+		userInfo, err = dingTalkUserInfo(cfg, accessToken)
+	case DialectWeCom:
+		userInfo, err = weComUserInfo(accessToken)
+	default:
+		userInfo, err = fetchUserInfo(cfg, accessToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 映射用户属性
+	mappedUserInfo := make(map[string]interface{})
+	for opAttr, oidcClaim := range cfg.AttrMapping {
+		if value, ok := GetNestedValue(userInfo, opAttr); ok {
+			mappedUserInfo[oidcClaim] = value
+		}
+	}
+	// sub 必须是字符串，GitHub、GitLab 等 OP 的用户 ID 是数字
+	if id, ok := mappedUserInfo["sub"].(float64); ok {
+		mappedUserInfo["sub"] = strconv.FormatFloat(id, 'f', -1, 64)
+	}
+
+	// 保留未映射的属性
+	for key, value := range userInfo {
+		if _, mapped := cfg.AttrMapping[key]; !mapped {
+			mappedUserInfo[key] = value
+		}
+	}
+
+	return mappedUserInfo, nil
+}
+
+// fetchUserInfo 以 Bearer 令牌请求 OP 的标准用户信息接口
+func fetchUserInfo(cfg *model.Config, accessToken string) (map[string]interface{}, error) {
 	// 创建请求
-	req, err := http.NewRequest("GET", config.AppConfig.OPUserInfoURL, nil)
+	req, err := http.NewRequest("GET", cfg.OPUserInfoURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create userinfo request: %v", err)
 	}
 
 	// 添加 Authorization 头
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Accept", "application/json")
 
 	// 发送请求
 	client := &http.Client{}
@@ -93,21 +186,5 @@ func GetUserInfoFromOP(accessToken string) (map[string]interface{}, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
 		return nil, fmt.Errorf("failed to decode userinfo response: %v", err)
 	}
-
-	// 映射用户属性
-	mappedUserInfo := make(map[string]interface{})
-	for opAttr, oidcClaim := range config.AppConfig.AttrMapping {
-		if value, ok := GetNestedValue(userInfo, opAttr); ok {
-			mappedUserInfo[oidcClaim] = value
-		}
-	}
-
-	// 保留未映射的属性
-	for key, value := range userInfo {
-		if _, mapped := config.AppConfig.AttrMapping[key]; !mapped {
-			mappedUserInfo[key] = value
-		}
-	}
-
-	return mappedUserInfo, nil
+	return userInfo, nil
 }
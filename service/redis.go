@@ -2,70 +2,211 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"oidc-bridge/config"
+	"oidc-bridge/model"
 	"oidc-bridge/utils"
+	"os"
 	"time"
 )
 
-var (
-	RedisClient *redis.Client
-	useRedis    bool
-)
+var RedisClient redis.UniversalClient
 
-func InitRedis() {
-	// 如果配置了Redis地址，则初始化Redis客户端
-	if config.AppConfig.RedisAddr != "" {
-		RedisClient = redis.NewClient(&redis.Options{
-			Addr: config.AppConfig.RedisAddr,
-		})
+// redisConfigured 是否配置了 Redis
+func redisConfigured() bool {
+	cfg := config.Current()
+	return cfg.RedisAddr != "" || len(cfg.Redis.Addrs) > 0
+}
 
-		// 测试Redis连接
-		_, err := RedisClient.Ping(context.Background()).Result()
-		if err != nil {
-			utils.ErrorLogger.Printf("Failed to connect to Redis: %v", err)
-			utils.InfoLogger.Println("Falling back to memory cache")
-			useRedis = false
-			InitMemoryCache()
-		} else {
-			utils.InfoLogger.Println("Successfully connected to Redis")
-			useRedis = true
-		}
-	} else {
+// pingTimeout 健康检查和重连时检测连接的超时时间
+const pingTimeout = 2 * time.Second
+
+// InitRedis 初始化 Redis 存储，Redis 不可达时按 redis.failure_policy 处理
+func InitRedis() error {
+	if !redisConfigured() {
 		fmt.Println("Redis not configured, using memory cache")
-		useRedis = false
 		InitMemoryCache()
+		return nil
 	}
-}
 
-func SetNonce(clientID, redirectURI, nonce string) error {
-	cacheKey := "nonce:" + clientID + ":" + redirectURI
+	cfg := config.Current()
+	redisConfig := cfg.Redis
+	policy := redisConfig.FailurePolicy
+	switch policy {
+	case "":
+		policy = StoreFailureFallback
+	case StoreFailureFail, StoreFailureFallback, StoreFailureDegrade:
+	default:
+		return fmt.Errorf("unsupported redis failure_policy: %s", policy)
+	}
 
-	if useRedis {
-		return RedisClient.Set(context.Background(), cacheKey, nonce, time.Duration(config.AppConfig.NonceCacheTTL)*time.Second).Err()
+	// 1. 创建客户端，配置错误无法通过重连恢复
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		if policy != StoreFailureFallback {
+			return fmt.Errorf("failed to configure Redis: %w", err)
+		}
+		utils.ErrorLogger.Printf("Failed to configure Redis: %v", err)
+		utils.InfoLogger.Println("Falling back to memory cache")
+		InitMemoryCache()
+		return nil
 	}
+	RedisClient = client
+	primary := NewRedisStore(RedisClient, redisConfig.KeyPrefix)
 
-	// 使用本地内存缓存
-	ttl := time.Duration(config.AppConfig.NonceCacheTTL) * time.Second
-	GlobalMemoryCache.Set(cacheKey, nonce, ttl)
-	utils.DebugLogger.Printf("Set nonce in memory cache: %s", cacheKey)
+	// 2. fallback 策略下 Redis 不可用期间使用本进程内存
+	var fallback Store
+	if policy == StoreFailureFallback {
+		InitMemoryCache()
+		fallback = GlobalStore
+	}
+	maxInterval := defaultMaxReconnectInterval
+	if redisConfig.MaxReconnectInterval > 0 {
+		maxInterval = time.Duration(redisConfig.MaxReconnectInterval) * time.Second
+	}
+	store := NewFailoverStore(primary, primary.Ping, fallback,
+		time.Duration(redisConfig.ReconnectInterval)*time.Second, maxInterval)
+
+	// 3. 测试连接，失败时按策略拒绝启动或在后台重连
+	if err := primary.Ping(); err != nil {
+		if policy == StoreFailureFail {
+			return fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		store.MarkUnavailable(err)
+	} else {
+		utils.InfoLogger.Println("Successfully connected to Redis")
+	}
+	GlobalStore = store
 	return nil
 }
 
-func GetNonce(clientID, redirectURI string) (string, error) {
-	cacheKey := "nonce:" + clientID + ":" + redirectURI
+// NewRedisClient 根据配置创建 Redis 客户端
+// 配置了 master_name 时使用 Sentinel，配置了多个地址时使用 Cluster，否则使用单节点
+func NewRedisClient(cfg *model.Config) (redis.UniversalClient, error) {
+	redisConfig := cfg.Redis
+	addrs := redisConfig.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{cfg.RedisAddr}
+	}
+
+	options := &redis.UniversalOptions{
+		Addrs:            addrs,
+		Username:         redisConfig.Username,
+		Password:         redisConfig.Password,
+		DB:               redisConfig.DB,
+		MasterName:       redisConfig.MasterName,
+		SentinelPassword: redisConfig.SentinelPassword,
+	}
+
+	if redisConfig.TLS.Enabled {
+		tlsConfig, err := redisTLSConfig(redisConfig.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options.TLSConfig = tlsConfig
+	}
+
+	return redis.NewUniversalClient(options), nil
+}
 
-	if useRedis {
-		return RedisClient.Get(context.Background(), cacheKey).Result()
+// redisTLSConfig 构建 Redis TLS 配置，支持自定义 CA 和客户端证书
+func redisTLSConfig(tlsConfig model.RedisTLSConfig) (*tls.Config, error) {
+	result := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         tlsConfig.ServerName,
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify, // #nosec G402 -- 仅在显式配置时跳过校验
 	}
 
-	// 使用本地内存缓存
-	if value, exists := GlobalMemoryCache.Get(cacheKey); exists {
-		utils.DebugLogger.Printf("Get nonce from memory cache: %s", cacheKey)
-		return value, nil
+	if tlsConfig.CAFile != "" {
+		caData, err := os.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in Redis CA file %s", tlsConfig.CAFile)
+		}
+		result.RootCAs = pool
+	}
+
+	if tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis client certificate: %w", err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	return result, nil
+}
+
+// RedisStore 基于 Redis 的 Store 实现，多个副本共享同一份数据
+// 所有键都会加上 keyPrefix，使多个桥接服务可以共用一个 Redis
+type RedisStore struct {
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+// NewRedisStore 创建 Redis 存储
+func NewRedisStore(client redis.UniversalClient, keyPrefix string) *RedisStore {
+	return &RedisStore{client: client, keyPrefix: keyPrefix}
+}
+
+// Ping 检查 Redis 连接
+func (s *RedisStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Set(key, value string, ttl time.Duration) error {
+	return s.client.Set(context.Background(), s.keyPrefix+key, value, redisTTL(ttl)).Err()
+}
+
+func (s *RedisStore) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(context.Background(), s.keyPrefix+key, value, redisTTL(ttl)).Result()
+}
+
+func (s *RedisStore) Get(key string) (string, error) {
+	value, err := s.client.Get(context.Background(), s.keyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (s *RedisStore) GetDel(key string) (string, error) {
+	value, err := s.client.GetDel(context.Background(), s.keyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (s *RedisStore) Delete(key string) error {
+	return s.client.Del(context.Background(), s.keyPrefix+key).Err()
+}
+
+// incrScript 计数器首次创建时设置过期时间，兼容不支持 EXPIRE NX 的 Redis 版本
+var incrScript = redis.NewScript(`
+local value = redis.call("INCR", KEYS[1])
+if value == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return value
+`)
+
+func (s *RedisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(context.Background(), s.client, []string{s.keyPrefix + key}, ttl.Milliseconds()).Int64()
+}
+
+// redisTTL 将不大于 0 的 ttl 转换为 Redis 的永不过期
+func redisTTL(ttl time.Duration) time.Duration {
+	if ttl < 0 {
+		return 0
 	}
-	utils.DebugLogger.Printf("Nonce not found in memory cache: %s", cacheKey)
-	return "", errors.New("nonce not found")
+	return ttl
 }
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+)
+
+// defaultRefreshTokenTTL 未配置 refresh_token_ttl 时的默认保存时长（30 天）
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// refreshTokenKey 以 refresh_token 的摘要作为缓存键，避免在缓存中保存明文令牌
+func refreshTokenKey(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return "refresh:" + hex.EncodeToString(sum[:])
+}
+
+// refreshTokenTTL 返回 refresh_token 记录的保存时长，client 单独配置的 refresh_token_ttl 优先
+func refreshTokenTTL(cfg *model.Config, clientID string) time.Duration {
+	if client := findClient(cfg, clientID); client != nil && client.RefreshTokenTTL > 0 {
+		return time.Duration(client.RefreshTokenTTL) * time.Second
+	}
+	if cfg.RefreshTokenTTL > 0 {
+		return time.Duration(cfg.RefreshTokenTTL) * time.Second
+	}
+	return defaultRefreshTokenTTL
+}
+
+// SaveRefreshToken 保存 refresh_token 对应的原始授权信息
+func SaveRefreshToken(cfg *model.Config, refreshToken string, record *model.RefreshTokenRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := GlobalStore.Set(refreshTokenKey(refreshToken), string(data), refreshTokenTTL(cfg, record.ClientID)); err != nil {
+		return err
+	}
+	utils.DebugLogger.Printf("Saved refresh token record for client: %s", record.ClientID)
+	return nil
+}
+
+// GetRefreshToken 获取 refresh_token 对应的原始授权信息，不存在时返回 nil
+func GetRefreshToken(refreshToken string) (*model.RefreshTokenRecord, error) {
+	value, err := GlobalStore.Get(refreshTokenKey(refreshToken))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record model.RefreshTokenRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// DeleteRefreshToken 删除 refresh_token 对应的授权信息
+func DeleteRefreshToken(refreshToken string) error {
+	return GlobalStore.Delete(refreshTokenKey(refreshToken))
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+)
+
+// ErrInvalidRegistrationToken 注册访问令牌缺失、错误，或对应的 client 不存在
+var ErrInvalidRegistrationToken = errors.New("invalid registration access token")
+
+// 动态注册客户端的 token_endpoint_auth_method，未指定时按 RFC 7591 默认为 client_secret_basic
+const (
+	AuthMethodNone              = "none"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	defaultRegisteredAuthMethod = AuthMethodClientSecretBasic
+)
+
+// RegistrationError 客户端元数据不合法，Code 为 RFC 7591 定义的错误码
+type RegistrationError struct {
+	Code        string
+	Description string
+}
+
+func (e *RegistrationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// registeredClient 存储中保存的动态注册客户端，client_secret 和注册访问令牌只保存摘要
+type registeredClient struct {
+	Metadata          model.ClientRegistration `json:"metadata"`
+	ClientSecret      string                   `json:"client_secret_sha256,omitempty"`
+	RegistrationToken string                   `json:"registration_token_sha256"`
+}
+
+// hasClientSecret 判断客户端是否签发了 client_secret，兼容以明文保存密钥的旧记录
+func (r *registeredClient) hasClientSecret() bool {
+	return r.ClientSecret != "" || r.Metadata.ClientSecret != ""
+}
+
+// RegistrationEnabled 判断提供方是否开放动态客户端注册
+func RegistrationEnabled(cfg *model.Config) bool {
+	return cfg.Registration.Enabled
+}
+
+// VerifyInitialAccessToken 校验注册请求携带的初始访问令牌
+func VerifyInitialAccessToken(cfg *model.Config, token string) bool {
+	expected := cfg.Registration.InitialAccessToken
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// registeredClientKey 存储中保存动态注册客户端的键，各提供方的客户端分别保存
+func registeredClientKey(cfg *model.Config, clientID string) string {
+	if cfg.Name != "" {
+		return "client:" + cfg.Name + ":" + clientID
+	}
+	return "client:" + clientID
+}
+
+// hashSecret 计算随机生成的 client_secret 或注册访问令牌的摘要，两者都有 256 位熵，无需加盐
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// RegisterClient 校验元数据并注册新客户端，返回的元数据中包含明文的 client_secret 和注册访问令牌，之后无法再次读取
+func RegisterClient(cfg *model.Config, metadata *model.ClientRegistration) (*model.ClientRegistration, error) {
+	if err := normalizeClientMetadata(metadata); err != nil {
+		return nil, err
+	}
+
+	// 1. 生成 client_id、client_secret 和注册访问令牌
+	clientID, err := NewRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	registrationToken, err := NewRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	metadata.ClientID = clientID
+	metadata.ClientIDIssuedAt = time.Now().Unix()
+	metadata.ClientSecret = ""
+	metadata.ClientSecretExpiresAt = nil
+	record := &registeredClient{RegistrationToken: hashSecret(registrationToken)}
+	var clientSecret string
+	if usesClientSecret(metadata.TokenEndpointAuthMethod) {
+		if clientSecret, err = NewRandomToken(); err != nil {
+			return nil, err
+		}
+		record.ClientSecret = hashSecret(clientSecret)
+		neverExpires := int64(0)
+		metadata.ClientSecretExpiresAt = &neverExpires
+	}
+
+	// 2. 保存到存储，永不过期
+	record.Metadata = *metadata
+	if err := saveRegisteredClient(cfg, record); err != nil {
+		return nil, err
+	}
+	utils.InfoLogger.Printf("Registered client: %s (%s)", clientID, metadata.ClientName)
+
+	metadata.ClientSecret = clientSecret
+	metadata.RegistrationAccessToken = registrationToken
+	return metadata, nil
+}
+
+// GetRegisteredClient 使用注册访问令牌读取客户端元数据，不包含 client_secret
+func GetRegisteredClient(cfg *model.Config, clientID, registrationToken string) (*model.ClientRegistration, error) {
+	record, err := authorizeRegistration(cfg, clientID, registrationToken)
+	if err != nil {
+		return nil, err
+	}
+	metadata := record.Metadata
+	metadata.ClientSecret = ""
+	return &metadata, nil
+}
+
+// UpdateRegisteredClient 使用注册访问令牌整体替换客户端元数据，client_id 和 client_secret 保持不变，响应中不包含 client_secret
+func UpdateRegisteredClient(cfg *model.Config, clientID, registrationToken string, metadata *model.ClientRegistration) (*model.ClientRegistration, error) {
+	record, err := authorizeRegistration(cfg, clientID, registrationToken)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.ClientID != clientID {
+		return nil, &RegistrationError{Code: "invalid_client_metadata", Description: "client_id does not match the registration"}
+	}
+	if metadata.ClientSecret != "" && !clientSecretMatches(record.clientConfig(), metadata.ClientSecret) {
+		return nil, &RegistrationError{Code: "invalid_client_metadata", Description: "client_secret does not match the registration"}
+	}
+	if err := normalizeClientMetadata(metadata); err != nil {
+		return nil, err
+	}
+	if usesClientSecret(metadata.TokenEndpointAuthMethod) != record.hasClientSecret() {
+		return nil, &RegistrationError{Code: "invalid_client_metadata", Description: "token_endpoint_auth_method cannot switch between secret and non-secret methods"}
+	}
+
+	metadata.ClientIDIssuedAt = record.Metadata.ClientIDIssuedAt
+	metadata.ClientSecret = record.Metadata.ClientSecret
+	metadata.ClientSecretExpiresAt = record.Metadata.ClientSecretExpiresAt
+	metadata.RegistrationAccessToken = ""
+	metadata.RegistrationClientURI = ""
+	record.Metadata = *metadata
+	if err := saveRegisteredClient(cfg, record); err != nil {
+		return nil, err
+	}
+	utils.InfoLogger.Printf("Updated registered client: %s", clientID)
+	metadata.ClientSecret = ""
+	return metadata, nil
+}
+
+// DeleteRegisteredClient 使用注册访问令牌注销客户端
+func DeleteRegisteredClient(cfg *model.Config, clientID, registrationToken string) error {
+	if _, err := authorizeRegistration(cfg, clientID, registrationToken); err != nil {
+		return err
+	}
+	if err := GlobalStore.Delete(registeredClientKey(cfg, clientID)); err != nil {
+		return err
+	}
+	utils.InfoLogger.Printf("Deleted registered client: %s", clientID)
+	return nil
+}
+
+// authorizeRegistration 读取客户端并校验注册访问令牌，client 不存在时同样返回 ErrInvalidRegistrationToken
+func authorizeRegistration(cfg *model.Config, clientID, registrationToken string) (*registeredClient, error) {
+	record, err := loadRegisteredClient(cfg, clientID)
+	if errors.Is(err, ErrUnknownClient) {
+		return nil, ErrInvalidRegistrationToken
+	} else if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(record.RegistrationToken), []byte(hashSecret(registrationToken))) != 1 {
+		return nil, ErrInvalidRegistrationToken
+	}
+	return record, nil
+}
+
+// loadRegisteredClient 从存储中读取动态注册的客户端，不存在时返回 ErrUnknownClient
+func loadRegisteredClient(cfg *model.Config, clientID string) (*registeredClient, error) {
+	if clientID == "" {
+		return nil, ErrUnknownClient
+	}
+	value, err := GlobalStore.Get(registeredClientKey(cfg, clientID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUnknownClient
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record registeredClient
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func saveRegisteredClient(cfg *model.Config, record *registeredClient) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return GlobalStore.Set(registeredClientKey(cfg, record.Metadata.ClientID), string(data), 0)
+}
+
+// normalizeClientMetadata 校验客户端元数据并补全默认值
+func normalizeClientMetadata(metadata *model.ClientRegistration) error {
+	// 1. 必须注册至少一个不含 fragment 的 https 回调地址，本机回环地址（RFC 8252 7.3）可以使用 http
+	if len(metadata.RedirectURIs) == 0 {
+		return &RegistrationError{Code: "invalid_redirect_uri", Description: "redirect_uris is required"}
+	}
+	for _, redirectURI := range metadata.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" {
+			return &RegistrationError{Code: "invalid_redirect_uri", Description: "invalid redirect_uri: " + redirectURI}
+		}
+		if parsed.Scheme != "https" && !(parsed.Scheme == "http" && isLoopbackHost(parsed.Hostname())) {
+			return &RegistrationError{Code: "invalid_redirect_uri", Description: "redirect_uri must use https unless it is a loopback address: " + redirectURI}
+		}
+	}
+
+	// 2. 桥接服务只支持授权码流程
+	if len(metadata.ResponseTypes) == 0 {
+		metadata.ResponseTypes = []string{"code"}
+	}
+	for _, responseType := range metadata.ResponseTypes {
+		if responseType != "code" {
+			return &RegistrationError{Code: "invalid_client_metadata", Description: "unsupported response_type: " + responseType}
+		}
+	}
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{"authorization_code"}
+	}
+	for _, grantType := range metadata.GrantTypes {
+		if grantType != "authorization_code" && grantType != "refresh_token" {
+			return &RegistrationError{Code: "invalid_client_metadata", Description: "unsupported grant_type: " + grantType}
+		}
+	}
+
+	// 3. 客户端认证方式，client_secret_jwt 需要保存明文密钥作为 HMAC 密钥，动态注册的客户端不支持
+	switch metadata.TokenEndpointAuthMethod {
+	case "":
+		metadata.TokenEndpointAuthMethod = defaultRegisteredAuthMethod
+	case AuthMethodNone, AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodPrivateKeyJWT:
+	default:
+		return &RegistrationError{Code: "invalid_client_metadata", Description: "unsupported token_endpoint_auth_method: " + metadata.TokenEndpointAuthMethod}
+	}
+
+	// 4. private_key_jwt 需要 jwks 或 jwks_uri 二选一
+	if metadata.JWKS != nil && metadata.JWKSURI != "" {
+		return &RegistrationError{Code: "invalid_client_metadata", Description: "jwks and jwks_uri must not both be present"}
+	}
+	if metadata.TokenEndpointAuthMethod == AuthMethodPrivateKeyJWT && metadata.JWKS == nil && metadata.JWKSURI == "" {
+		return &RegistrationError{Code: "invalid_client_metadata", Description: "private_key_jwt requires jwks or jwks_uri"}
+	}
+	if metadata.JWKSURI != "" {
+		if err := ValidateJWKSURI(metadata.JWKSURI); err != nil {
+			return &RegistrationError{Code: "invalid_client_metadata", Description: err.Error()}
+		}
+	}
+	return nil
+}
+
+// isLoopbackHost 判断主机名是否为本机回环地址
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// usesClientSecret 判断认证方式是否需要签发 client_secret
+func usesClientSecret(method string) bool {
+	return method != AuthMethodNone && method != AuthMethodPrivateKeyJWT
+}
+
+// clientConfig 将动态注册的元数据转换为客户端注册表中的配置，scope 非空时限定可用的 scope
+func (r *registeredClient) clientConfig() *model.ClientConfig {
+	return &model.ClientConfig{
+		ClientID:                r.Metadata.ClientID,
+		ClientSecret:            r.Metadata.ClientSecret,
+		ClientSecretSHA256:      r.ClientSecret,
+		TokenEndpointAuthMethod: r.Metadata.TokenEndpointAuthMethod,
+		JWKS:                    r.Metadata.JWKS,
+		JWKSURI:                 r.Metadata.JWKSURI,
+		RedirectURIs:            r.Metadata.RedirectURIs,
+		AllowedScopes:           strings.Fields(r.Metadata.Scope),
+	}
+}
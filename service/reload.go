@@ -0,0 +1,71 @@
+package service
+
+import (
+	"strings"
+	"sync"
+
+	"oidc-bridge/config"
+	"oidc-bridge/utils"
+)
+
+// reloadMutex 保证同一时间只进行一次配置重载
+var reloadMutex sync.Mutex
+
+// storeConfigKeys 存储相关的配置项，存储在启动时初始化，修改后需要重启才能生效
+var storeConfigKeys = []string{"store", "redis_addr", "redis", "sql", "memory"}
+
+// ReloadConfig 重新读取配置文件，校验通过并加载好签名密钥后替换当前配置，新的请求使用新配置
+// 任一步骤失败时保留正在使用的配置和密钥
+func ReloadConfig() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	// 1. 读取并校验新配置
+	old := config.Current()
+	cfg, err := config.ReadConfig()
+	if err == nil {
+		err = config.CheckReload(old, cfg)
+	}
+	if err != nil {
+		utils.ErrorLogger.Printf("Rejected config reload, keeping running config: %v", err)
+		return err
+	}
+
+	// 2. 按新配置准备签名密钥
+	if err := ensureSigningKeys(cfg); err != nil {
+		utils.ErrorLogger.Printf("Rejected config reload, keeping running config: %v", err)
+		return err
+	}
+	managers, err := newKeyManagers(cfg)
+	if err != nil {
+		utils.ErrorLogger.Printf("Rejected config reload, keeping running config: %v", err)
+		return err
+	}
+
+	// 3. 替换配置和密钥管理器
+	config.SetCurrent(cfg)
+	replaceKeyManagers(managers)
+
+	// 4. 记录发生变化的配置项
+	changed := config.Diff(old, cfg)
+	if len(changed) == 0 {
+		utils.InfoLogger.Println("Config reloaded, no changes")
+		return nil
+	}
+	utils.InfoLogger.Printf("Config reloaded, changed: %s", strings.Join(changed, ", "))
+	for _, key := range changed {
+		if isStoreConfigKey(key) {
+			utils.InfoLogger.Printf("Store settings changed, restart required to apply: %s", key)
+		}
+	}
+	return nil
+}
+
+func isStoreConfigKey(key string) bool {
+	for _, storeKey := range storeConfigKeys {
+		if key == storeKey || strings.HasPrefix(key, storeKey+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"oidc-bridge/model"
+)
+
+// RequestEncodingJSON 以 JSON 对象提交令牌请求，默认为表单编码
+const RequestEncodingJSON = "json"
+
+// placeholderPattern 匹配请求模板中的 {client_id} 等占位符
+var placeholderPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// expandPlaceholders 将 value 中的 {name} 替换为标准参数 name 的值，没有对应参数的占位符保持原样
+func expandPlaceholders(value string, standard url.Values) string {
+	return placeholderPattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := match[1 : len(match)-1]
+		if values, ok := standard[name]; ok && len(values) > 0 {
+			return values[0]
+		}
+		return match
+	})
+}
+
+// BuildOPParams 按请求模板生成发往 OP 的参数
+// 1. fixed 中的固定参数（如 op_authorize_url 中的查询参数）和 extra_params 的值可以引用标准参数，如 app_id={client_id}
+// 2. 标准参数按 params 改名，改名为空字符串时不发送
+// 3. 同名参数以标准参数为准，其次是 extra_params
+func BuildOPParams(tmpl model.OPRequestConfig, standard, fixed url.Values) url.Values {
+	params := url.Values{}
+	for name, values := range fixed {
+		for _, value := range values {
+			params.Add(name, expandPlaceholders(value, standard))
+		}
+	}
+	for _, param := range tmpl.ExtraParams {
+		name, value, _ := strings.Cut(param, "=")
+		params.Set(name, expandPlaceholders(value, standard))
+	}
+	for name, values := range standard {
+		if renamed, ok := tmpl.Params[name]; ok {
+			name = renamed
+		}
+		if name == "" {
+			continue
+		}
+		params[name] = values
+	}
+	return params
+}
+
+// newOPTokenRequest 按 op_token_request 模板创建发往 OP 令牌端点的 POST 请求
+func newOPTokenRequest(cfg *model.Config, standard url.Values) (*http.Request, error) {
+	tmpl := cfg.OPTokenRequest
+	params := BuildOPParams(tmpl, standard, nil)
+
+	var body io.Reader
+	contentType := "application/x-www-form-urlencoded"
+	if tmpl.Encoding == RequestEncodingJSON {
+		// JSON 请求体中每个参数只取第一个值
+		object := make(map[string]string, len(params))
+		for name := range params {
+			object[name] = params.Get(name)
+		}
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(string(data))
+		contentType = "application/json"
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequest("POST", cfg.OPTokenURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	// GitHub 等 OP 只有在明确要求时才以 JSON 返回令牌
+	req.Header.Set("Accept", "application/json")
+	for name, value := range tmpl.Headers {
+		req.Header.Set(name, expandPlaceholders(value, standard))
+	}
+	return req, nil
+}
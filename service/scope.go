@@ -0,0 +1,36 @@
+package service
+
+import (
+	"slices"
+	"strings"
+
+	"oidc-bridge/model"
+)
+
+// MapScopes 将 RP 请求的 OIDC scope 映射为 OP 的 OAuth2 scope
+// openid 由桥接服务自行处理，不会转发给 OP；映射为空字符串的 scope 会被丢弃
+// op_required_scopes 中的 scope 总是转发给 OP，用于 OP 自身要求的 scope（如 Slack 的 openid、GitLab 的 read_user）
+func MapScopes(cfg *model.Config, scope string) ([]string, bool) {
+	hasOpenID := false
+	var mappedScopes []string
+	for _, s := range strings.Fields(scope) {
+		if s == "openid" {
+			hasOpenID = true
+			continue
+		}
+		if mapped, ok := cfg.ScopeMapping[s]; ok {
+			if mapped == "" {
+				continue
+			}
+			mappedScopes = append(mappedScopes, mapped)
+		} else {
+			mappedScopes = append(mappedScopes, s)
+		}
+	}
+	for _, required := range cfg.OPRequiredScopes {
+		if !slices.Contains(mappedScopes, required) {
+			mappedScopes = append(mappedScopes, required)
+		}
+	}
+	return mappedScopes, hasOpenID
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"oidc-bridge/utils"
+
+	// 注册 database/sql 驱动：sqlite (modernc.org/sqlite) 和 pgx (PostgreSQL)
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// SQLDriverSQLite 单节点部署和测试使用的 SQLite
+	SQLDriverSQLite = "sqlite"
+	// SQLDriverPostgres 多副本共享的 PostgreSQL
+	SQLDriverPostgres = "postgres"
+)
+
+// defaultSQLCleanupInterval 未配置 sql.cleanup_interval 时清理过期数据的间隔
+const defaultSQLCleanupInterval = time.Minute
+
+// sqlMigrations 按顺序执行的建表语句，已执行的版本记录在 oidc_bridge_schema 中
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS oidc_bridge_store (
+		store_key  VARCHAR(255) PRIMARY KEY,
+		value      TEXT NOT NULL,
+		expires_at BIGINT
+	)`,
+	`CREATE INDEX IF NOT EXISTS oidc_bridge_store_expires_at ON oidc_bridge_store (expires_at)`,
+}
+
+// SQLStore 基于 SQL 数据库的 Store 实现，数据在重启后保留，使用 PostgreSQL 时可在副本之间共享
+// 过期时间以毫秒时间戳保存，NULL 表示永不过期；读取时过滤过期数据，后台定期删除
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+	stop   chan struct{}
+}
+
+// NewSQLStore 连接数据库并执行建表迁移，cleanupInterval 不大于 0 时使用默认间隔
+func NewSQLStore(driver, dsn string, cleanupInterval time.Duration) (*SQLStore, error) {
+	var driverName string
+	switch driver {
+	case SQLDriverSQLite:
+		driverName = "sqlite"
+	case SQLDriverPostgres:
+		driverName = "pgx"
+	default:
+		return nil, fmt.Errorf("unsupported SQL driver: %s", driver)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == SQLDriverSQLite {
+		// SQLite 只允许一个写连接，同时保证 :memory: 数据库在所有操作间共享
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store := &SQLStore{db: db, driver: driver, stop: make(chan struct{})}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate SQL store: %w", err)
+	}
+
+	if cleanupInterval <= 0 {
+		cleanupInterval = defaultSQLCleanupInterval
+	}
+	go store.cleanupLoop(cleanupInterval)
+	return store, nil
+}
+
+// Close 停止后台清理并关闭数据库连接
+func (s *SQLStore) Close() error {
+	close(s.stop)
+	return s.db.Close()
+}
+
+// rebind 将 ? 占位符转换为 PostgreSQL 的 $n 格式
+func (s *SQLStore) rebind(query string) string {
+	if s.driver != SQLDriverPostgres {
+		return query
+	}
+
+	var builder strings.Builder
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(ch)
+	}
+	return builder.String()
+}
+
+// Ping 检查数据库连接
+func (s *SQLStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+// sqlMigrationLockID 迁移时持有的 PostgreSQL 咨询锁，值为 "oidc-bri" 的 ASCII 编码
+const sqlMigrationLockID int64 = 0x6f6964632d627269
+
+// migrate 在一个事务中执行尚未执行的迁移
+// 多个副本同时启动时，PostgreSQL 通过事务级咨询锁保证只有一个副本执行迁移，其余副本等待后读取到最新版本；
+// SQLite 同一时间只允许一个写事务，不需要额外加锁
+func (s *SQLStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.driver == SQLDriverPostgres {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, sqlMigrationLockID); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS oidc_bridge_schema (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM oidc_bridge_schema`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqlMigrations); version++ {
+		if _, err := tx.Exec(sqlMigrations[version]); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(s.rebind(`INSERT INTO oidc_bridge_schema (version) VALUES (?)`), version+1); err != nil {
+			return err
+		}
+		utils.InfoLogger.Printf("Applied SQL store migration %d", version+1)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.DeleteExpired(); err != nil {
+				utils.ErrorLogger.Printf("Failed to delete expired store items: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired 删除已过期的数据
+func (s *SQLStore) DeleteExpired() error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM oidc_bridge_store WHERE expires_at IS NOT NULL AND expires_at <= ?`), nowMillis())
+	return err
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// expiresAt 计算过期时间戳，ttl 不大于 0 时返回 NULL
+func expiresAt(ttl time.Duration) sql.NullInt64 {
+	if ttl <= 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: time.Now().Add(ttl).UnixMilli(), Valid: true}
+}
+
+func (s *SQLStore) Set(key, value string, ttl time.Duration) error {
+	_, err := s.db.Exec(s.rebind(`
+		INSERT INTO oidc_bridge_store (store_key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (store_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`),
+		key, value, expiresAt(ttl))
+	return err
+}
+
+func (s *SQLStore) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	// 已存在但过期的数据视为不存在，可以被覆盖
+	result, err := s.db.Exec(s.rebind(`
+		INSERT INTO oidc_bridge_store (store_key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (store_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE oidc_bridge_store.expires_at IS NOT NULL AND oidc_bridge_store.expires_at <= ?`),
+		key, value, expiresAt(ttl), nowMillis())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *SQLStore) Get(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(s.rebind(`
+		SELECT value FROM oidc_bridge_store
+		WHERE store_key = ? AND (expires_at IS NULL OR expires_at > ?)`),
+		key, nowMillis()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (s *SQLStore) GetDel(key string) (string, error) {
+	var value string
+	var expires sql.NullInt64
+	err := s.db.QueryRow(s.rebind(`DELETE FROM oidc_bridge_store WHERE store_key = ? RETURNING value, expires_at`), key).Scan(&value, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	if expires.Valid && expires.Int64 <= nowMillis() {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *SQLStore) Delete(key string) error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM oidc_bridge_store WHERE store_key = ?`), key)
+	return err
+}
+
+func (s *SQLStore) Incr(key string, ttl time.Duration) (int64, error) {
+	// 计数器不存在或已过期时从 1 开始并设置过期时间，否则保留原过期时间
+	now := nowMillis()
+	var value string
+	err := s.db.QueryRow(s.rebind(`
+		INSERT INTO oidc_bridge_store (store_key, value, expires_at) VALUES (?, '1', ?)
+		ON CONFLICT (store_key) DO UPDATE SET
+			value = CASE WHEN oidc_bridge_store.expires_at IS NOT NULL AND oidc_bridge_store.expires_at <= ?
+				THEN '1' ELSE CAST(CAST(oidc_bridge_store.value AS BIGINT) + 1 AS VARCHAR(32)) END,
+			expires_at = CASE WHEN oidc_bridge_store.expires_at IS NOT NULL AND oidc_bridge_store.expires_at <= ?
+				THEN excluded.expires_at ELSE oidc_bridge_store.expires_at END
+		RETURNING value`),
+		key, expiresAt(ttl), now, now).Scan(&value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
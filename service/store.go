@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"oidc-bridge/config"
+	"oidc-bridge/utils"
+)
+
+// ErrNotFound 表示存储项不存在或已过期
+var ErrNotFound = errors.New("store: key not found")
+
+// Store 带过期时间的键值存储，授权事务、刷新令牌和防重放标记等数据都通过它保存
+// ttl 不大于 0 时表示永不过期
+type Store interface {
+	// Set 写入存储项
+	Set(key, value string, ttl time.Duration) error
+	// SetNX 仅当存储项不存在时写入，返回是否写入成功
+	SetNX(key, value string, ttl time.Duration) (bool, error)
+	// Get 读取存储项，不存在时返回 ErrNotFound
+	Get(key string) (string, error)
+	// GetDel 原子地读取并删除存储项，用于只能使用一次的数据，不存在时返回 ErrNotFound
+	GetDel(key string) (string, error)
+	// Delete 删除存储项，不存在时不报错
+	Delete(key string) error
+	// Incr 原子地将计数器加一并返回新值，计数器首次创建时设置过期时间
+	Incr(key string, ttl time.Duration) (int64, error)
+}
+
+// GlobalStore 全局存储实例，由 InitStore 初始化
+var GlobalStore Store
+
+const (
+	// StoreMemory 本地内存存储，重启后数据丢失
+	StoreMemory = "memory"
+	// StoreRedis Redis 存储
+	StoreRedis = "redis"
+	// StoreSQL SQL 数据库存储（SQLite 或 PostgreSQL）
+	StoreSQL = "sql"
+)
+
+// InitStore 根据 store 配置初始化全局存储
+// 未配置 store 时保持原有行为：配置了 redis_addr 则使用 Redis，否则使用内存
+func InitStore() error {
+	cfg := config.Current()
+	switch cfg.Store {
+	case "", StoreRedis:
+		return InitRedis()
+	case StoreMemory:
+		InitMemoryCache()
+	case StoreSQL:
+		sqlConfig := cfg.SQL
+		store, err := NewSQLStore(sqlConfig.Driver, sqlConfig.DSN, time.Duration(sqlConfig.CleanupInterval)*time.Second)
+		if err != nil {
+			return err
+		}
+		utils.InfoLogger.Printf("Using SQL store with driver: %s", sqlConfig.Driver)
+		GlobalStore = store
+	default:
+		return fmt.Errorf("unsupported store: %s", cfg.Store)
+	}
+	return nil
+}
+
+const (
+	// StoreModePrimary 使用配置的存储
+	StoreModePrimary = "primary"
+	// StoreModeFallback 配置的存储不可用，临时使用本进程内存
+	StoreModeFallback = "fallback"
+	// StoreModeUnavailable 配置的存储不可用，依赖存储的请求失败
+	StoreModeUnavailable = "unavailable"
+)
+
+// StoreStatus 存储健康状态，由 /healthz 返回
+type StoreStatus struct {
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Mode    string `json:"mode"`
+	Since   string `json:"since,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Memory 使用内存存储（包括 Redis 不可用时的回退）时的缓存统计
+	Memory *MemoryCacheStats `json:"memory,omitempty"`
+}
+
+// StoreHealth 检查全局存储的健康状态
+func StoreHealth() StoreStatus {
+	var status StoreStatus
+	var err error
+	switch store := GlobalStore.(type) {
+	case *FailoverStore:
+		status = store.Status()
+		status.Backend = StoreRedis
+		return status
+	case *RedisStore:
+		status.Backend = StoreRedis
+		err = store.Ping()
+	case *SQLStore:
+		status.Backend = StoreSQL
+		err = store.Ping()
+	case nil:
+		status.Backend = config.Current().Store
+		err = errors.New("store not initialized")
+	case *MemoryStore:
+		status.Backend = StoreMemory
+		stats := store.Stats()
+		status.Memory = &stats
+	default:
+		status.Backend = StoreMemory
+	}
+
+	status.Healthy = err == nil
+	status.Mode = StoreModePrimary
+	if err != nil {
+		status.Mode = StoreModeUnavailable
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// sharedStore 返回在多个副本之间共享的存储，GlobalStore 不共享时返回错误
+// 故障切换存储只在主存储可用时返回主存储，回退的本地内存不在副本间共享
+func sharedStore() (Store, error) {
+	switch store := GlobalStore.(type) {
+	case *RedisStore:
+		return store, nil
+	case *FailoverStore:
+		return store.Primary()
+	case *SQLStore:
+		if store.driver == SQLDriverPostgres {
+			return store, nil
+		}
+	}
+	return nil, errors.New("store is not shared between replicas, use redis or postgres")
+}
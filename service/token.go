@@ -1,28 +1,24 @@
 package service
 
 import (
+	"fmt"
 	"time"
 
-	"oidc-bridge/config"
+	"oidc-bridge/model"
 	"oidc-bridge/utils"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateIDToken(issuer, clientID, redirectURI string, userInfo map[string]interface{}) (string, error) {
-	// 1. 尝试获取 nonce (如果不存在也不报错)
-	var nonce string
-	if storedNonce, err := GetNonce(clientID, redirectURI); err == nil {
-		// 只有当 nonce 存在时才使用它
-		nonce = storedNonce
-	}
-
-	// 2. 构建 claims
+// GenerateIDToken 根据已映射的用户信息签发 ID Token，nonce 为空时不写入 nonce claim
+// clientID 在客户端注册表中时，使用该 client 的有效期并按其 claims 配置过滤用户属性
+func GenerateIDToken(cfg *model.Config, issuer, clientID, nonce string, userInfo map[string]interface{}) (string, error) {
+	// 1. 构建 claims
 	now := time.Now().Unix()
 	claims := jwt.MapClaims{
 		"iss": issuer,
 		"aud": clientID,
-		"exp": now + int64(config.AppConfig.IDTokenLifetime),
+		"exp": now + int64(idTokenLifetime(cfg, clientID)),
 		"iat": now,
 	}
 
@@ -31,24 +27,46 @@ func GenerateIDToken(issuer, clientID, redirectURI string, userInfo map[string]i
 		claims["nonce"] = nonce
 	}
 
-	// 3. 映射用户属性
-	for opAttr, oidcClaim := range config.AppConfig.AttrMapping {
-		if value, ok := userInfo[opAttr]; ok {
-			claims[oidcClaim] = value
+	// 2. 写入映射后的用户属性（userInfo 已由 GetUserInfoFromOP 完成映射）
+	userClaims := make(map[string]interface{})
+	for _, oidcClaim := range cfg.AttrMapping {
+		if value, ok := userInfo[oidcClaim]; ok {
+			userClaims[oidcClaim] = value
 		}
 	}
+	filterClaims(cfg, clientID, userClaims)
+	for claim, value := range userClaims {
+		claims[claim] = value
+	}
 
-	// 4. 加载私钥
-	privateKey, err := LoadPrivateKey()
+	// 3. 加载当前生效的私钥，并检查密钥类型与签名算法是否匹配
+	alg := SigningAlg(cfg)
+	method, err := SigningMethod(alg)
 	if err != nil {
-		utils.ErrorLogger.Printf("Failed to load private key: %v", err)
+		utils.ErrorLogger.Printf("Failed to get signing method: %v", err)
 		return "", err
 	}
 
-	// 5. 创建 token
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	privateKey, err := ActiveSigningKey(cfg)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to load signing key: %v", err)
+		return "", err
+	}
+	if err := CheckKeyAlg(privateKey.Public(), alg); err != nil {
+		utils.ErrorLogger.Printf("Private key does not match signing algorithm: %v", err)
+		return "", err
+	}
 
-	// 6. 签名 token
+	// 4. 创建 token，头部写入与 JWKS 一致的 kid
+	kid, err := KeyID(privateKey.Public())
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to compute key ID: %v", err)
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	// 5. 签名 token
 	signedToken, err := token.SignedString(privateKey)
 	if err != nil {
 		utils.ErrorLogger.Printf("Failed to sign token: %v", err)
@@ -58,3 +76,11 @@ func GenerateIDToken(issuer, clientID, redirectURI string, userInfo map[string]i
 	utils.DebugLogger.Printf("Generated ID token for client: %s", clientID)
 	return signedToken, nil
 }
+
+// UserSubject 从映射后的用户信息中获取 sub
+func UserSubject(userInfo map[string]interface{}) string {
+	if sub, ok := userInfo["sub"]; ok && sub != nil {
+		return fmt.Sprint(sub)
+	}
+	return ""
+}
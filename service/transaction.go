@@ -0,0 +1,168 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"oidc-bridge/model"
+	"oidc-bridge/utils"
+)
+
+// defaultAuthCodeTTL 未配置 auth_code_ttl 时桥接授权码的默认有效期
+const defaultAuthCodeTTL = 60 * time.Second
+
+// defaultNonceCacheTTL nonce_cache_ttl 未设置时授权事务的保存时长
+const defaultNonceCacheTTL = 10 * time.Minute
+
+// ErrTransactionNotFound 表示事务或授权码不存在、已过期或已被使用
+var ErrTransactionNotFound = errors.New("authorization transaction not found")
+
+// NewRandomToken 生成 URL 安全的随机令牌，用作事务 ID 和桥接授权码
+func NewRandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// transactionKey 以提供方名称区分授权事务和授权码的键，其他提供方的记录在查找时不存在，也不会被消费
+func transactionKey(prefix, provider, id string) string {
+	return prefix + ":" + provider + ":" + id
+}
+
+func authCodeKey(provider, code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return transactionKey("code", provider, hex.EncodeToString(sum[:]))
+}
+
+// authCodeTTL 返回提供方 cfg 的桥接授权码有效期
+func authCodeTTL(cfg *model.Config) time.Duration {
+	if cfg.AuthCodeTTL > 0 {
+		return time.Duration(cfg.AuthCodeTTL) * time.Second
+	}
+	return defaultAuthCodeTTL
+}
+
+// nonceCacheTTL 返回提供方 cfg 的授权事务和已兑换标记的保存时长，未配置时使用默认值，保证这些记录总会过期
+func nonceCacheTTL(cfg *model.Config) time.Duration {
+	if cfg.NonceCacheTTL > 0 {
+		return time.Duration(cfg.NonceCacheTTL) * time.Second
+	}
+	return defaultNonceCacheTTL
+}
+
+func saveTransactionRecord(key string, txn *model.AuthTransaction, ttl time.Duration) error {
+	data, err := json.Marshal(txn)
+	if err != nil {
+		return err
+	}
+	return GlobalStore.Set(key, string(data), ttl)
+}
+
+func takeTransactionRecord(key string) (*model.AuthTransaction, error) {
+	value, err := GlobalStore.GetDel(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrTransactionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var txn model.AuthTransaction
+	if err := json.Unmarshal([]byte(value), &txn); err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// SaveTransaction 保存等待 OP 回调的授权事务，保存时长为提供方 cfg 的 nonce_cache_ttl
+func SaveTransaction(cfg *model.Config, txnID string, txn *model.AuthTransaction) error {
+	if err := saveTransactionRecord(transactionKey("txn", txn.Provider, txnID), txn, nonceCacheTTL(cfg)); err != nil {
+		return err
+	}
+	utils.DebugLogger.Printf("Saved authorization transaction for client: %s", txn.ClientID)
+	return nil
+}
+
+// TakeTransaction 取出并删除提供方 provider 的授权事务，同一事务只能被回调使用一次
+func TakeTransaction(provider, txnID string) (*model.AuthTransaction, error) {
+	return takeTransactionRecord(transactionKey("txn", provider, txnID))
+}
+
+// SaveAuthCode 保存桥接服务签发的授权码及其对应的授权事务，有效期为提供方 cfg 的 auth_code_ttl
+func SaveAuthCode(cfg *model.Config, code string, txn *model.AuthTransaction) error {
+	if err := saveTransactionRecord(authCodeKey(txn.Provider, code), txn, authCodeTTL(cfg)); err != nil {
+		return err
+	}
+	utils.DebugLogger.Printf("Saved authorization code for client: %s", txn.ClientID)
+	return nil
+}
+
+// TakeAuthCode 取出并删除提供方 provider 签发的桥接授权码对应的授权事务，授权码只能兑换一次
+func TakeAuthCode(provider, code string) (*model.AuthTransaction, error) {
+	return takeTransactionRecord(authCodeKey(provider, code))
+}
+
+// pendingKey 以提供方名称、PKCE 方法和 code_challenge 的摘要作为透传模式授权上下文的键
+func pendingKey(provider, method, codeChallenge string) string {
+	sum := sha256.Sum256([]byte(codeChallenge))
+	return transactionKey("pending", provider, method+":"+hex.EncodeToString(sum[:]))
+}
+
+// SavePendingTransaction 透传模式下保存授权上下文
+// OP 直接回调 RP，桥接服务在授权时无法得知授权码，以每次授权唯一的 code_challenge 为键保存，
+// 在 /token 时由 code_verifier 找回并绑定到授权码上，并发登录互不覆盖
+func SavePendingTransaction(cfg *model.Config, codeChallenge, codeChallengeMethod string, txn *model.AuthTransaction) error {
+	if err := saveTransactionRecord(pendingKey(txn.Provider, codeChallengeMethod, codeChallenge), txn, nonceCacheTTL(cfg)); err != nil {
+		return err
+	}
+	utils.DebugLogger.Printf("Saved pending authorization for client: %s", txn.ClientID)
+	return nil
+}
+
+// TakePendingTransaction 取出并删除提供方 provider 下 code_verifier 对应的透传模式授权上下文，不存在时返回 ErrTransactionNotFound
+// 找到上下文即说明 code_verifier 与授权时的 code_challenge 匹配
+func TakePendingTransaction(provider, codeVerifier string) (*model.AuthTransaction, error) {
+	if !pkceValuePattern.MatchString(codeVerifier) {
+		return nil, ErrTransactionNotFound
+	}
+	for _, method := range []string{PKCEMethodS256, PKCEMethodPlain} {
+		txn, err := takeTransactionRecord(pendingKey(provider, method, deriveCodeChallenge(method, codeVerifier)))
+		if !errors.Is(err, ErrTransactionNotFound) {
+			return txn, err
+		}
+	}
+	return nil, ErrTransactionNotFound
+}
+
+// unboundKey 以提供方名称、client_id 和 redirect_uri 作为未使用 PKCE 的透传模式授权上下文的键
+func unboundKey(provider, clientID, redirectURI string) string {
+	sum := sha256.Sum256([]byte(clientID + "\x00" + redirectURI))
+	return transactionKey("unbound", provider, hex.EncodeToString(sum[:]))
+}
+
+// SaveUnboundTransaction 透传模式下保存未使用 PKCE 的授权上下文
+// 没有 code_challenge 时无法区分同一 RP 的多次授权，后保存的上下文覆盖之前的；需要并发登录互不干扰时应使用 PKCE 或开启 bridge_callback
+func SaveUnboundTransaction(cfg *model.Config, txn *model.AuthTransaction) error {
+	if err := saveTransactionRecord(unboundKey(txn.Provider, txn.ClientID, txn.RedirectURI), txn, nonceCacheTTL(cfg)); err != nil {
+		return err
+	}
+	utils.DebugLogger.Printf("Saved pending authorization without PKCE for client: %s", txn.ClientID)
+	return nil
+}
+
+// TakeUnboundTransaction 取出并删除提供方 provider 下 client_id 和 redirect_uri 对应的未使用 PKCE 的授权上下文，不存在时返回 ErrTransactionNotFound
+func TakeUnboundTransaction(provider, clientID, redirectURI string) (*model.AuthTransaction, error) {
+	return takeTransactionRecord(unboundKey(provider, clientID, redirectURI))
+}
+
+// ClaimAuthCode 将 OP 授权码标记为已兑换，同一授权码只能成功标记一次，标记保存提供方 cfg 的 nonce_cache_ttl
+func ClaimAuthCode(cfg *model.Config, code string) (bool, error) {
+	sum := sha256.Sum256([]byte(code))
+	return GlobalStore.SetNX("redeemed:"+hex.EncodeToString(sum[:]), "1", nonceCacheTTL(cfg))
+}
@@ -27,17 +27,17 @@ func loadTestConfig(configFile string) {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 }
 
 // setupTestWithConfig 设置测试环境并加载指定配置
 func setupTestWithConfig(configFile string) func() {
-	originalConfig := config.AppConfig
+	originalConfig := config.Current()
 	loadTestConfig(configFile)
-	return func() { config.AppConfig = originalConfig }
+	return func() { config.SetCurrent(originalConfig) }
 }
 
 func TestHandleAuthorize(t *testing.T) {
@@ -52,7 +52,7 @@ func TestHandleAuthorize(t *testing.T) {
 	c.Request = &http.Request{
 		Method: "GET",
 		URL: &url.URL{
-			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&state=test_state&nonce=test_nonce",
+			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&state=test_state&nonce=test_nonce&code_challenge=" + s256Challenge(testCodeVerifier) + "&code_challenge_method=S256",
 		},
 	}
 
@@ -74,13 +74,16 @@ func TestHandleAuthorize(t *testing.T) {
 		t.Error("Expected redirect location, got empty")
 	}
 
-	// 验证 nonce 是否存储在缓存中
-	storedNonce, exists := service.GlobalMemoryCache.Get("nonce:test_client:https://example.com/callback")
-	if !exists {
-		t.Error("nonce should be stored in cache")
+	// 验证 nonce 是否随授权上下文存储在缓存中
+	txn, err := service.TakePendingTransaction("", testCodeVerifier)
+	if err != nil {
+		t.Fatalf("authorization context should be stored in cache: %v", err)
+	}
+	if txn.Nonce != "test_nonce" {
+		t.Errorf("Expected nonce %s, got %s", "test_nonce", txn.Nonce)
 	}
-	if storedNonce != "test_nonce" {
-		t.Errorf("Expected nonce %s, got %s", "test_nonce", storedNonce)
+	if txn.Scope != "openid" {
+		t.Errorf("Expected scope %s, got %s", "openid", txn.Scope)
 	}
 }
 
@@ -96,7 +99,7 @@ func TestHandleAuthorizeScopeMapping(t *testing.T) {
 	c.Request = &http.Request{
 		Method: "GET",
 		URL: &url.URL{
-			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid profile email custom_scope&state=test_state&nonce=test_nonce",
+			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid profile email custom_scope&state=test_state&nonce=test_nonce" + testPKCEQuery,
 		},
 	}
 
@@ -153,7 +156,7 @@ func TestHandleAuthorizeScopeMappingUnmapped(t *testing.T) {
 	c.Request = &http.Request{
 		Method: "GET",
 		URL: &url.URL{
-			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid unmapped_scope&state=test_state&nonce=test_nonce",
+			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid unmapped_scope&state=test_state&nonce=test_nonce" + testPKCEQuery,
 		},
 	}
 
@@ -230,7 +233,7 @@ func TestHandleAuthorizeMissingNonce(t *testing.T) {
 	c.Request = &http.Request{
 		Method: "GET",
 		URL: &url.URL{
-			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&state=test_state",
+			RawQuery: "client_id=test_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&state=test_state" + testPKCEQuery,
 		},
 	}
 
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// authorizeViaBridge 在桥接回调模式下发起授权请求，返回重定向到 OP 的地址
+func authorizeViaBridge(t *testing.T, rawQuery string) *url.URL {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/authorize?"+rawQuery, nil)
+
+	handler.HandleAuthorize(c)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusFound, w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse redirect URL: %v", err)
+	}
+	return location
+}
+
+// callbackFromOP 模拟 OP 回调桥接服务，返回重定向到 RP 的地址
+func callbackFromOP(t *testing.T, rawQuery string) *url.URL {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/callback?"+rawQuery, nil)
+
+	handler.HandleCallback(c)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusFound, w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse redirect URL: %v", err)
+	}
+	return location
+}
+
+func TestBridgeCallbackFlow(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
+		t.Skip("Private key file not found, skipping bridge callback tests")
+	}
+
+	op := newFakeOP(t)
+	config.Current().OPTokenURL = op.URL + "/token"
+	config.Current().OPUserInfoURL = op.URL + "/userinfo"
+	config.Current().BridgeCallback = true
+	service.InitMemoryCache()
+
+	// 1. 同一 client 的两次并发登录，nonce 互不覆盖
+	first := authorizeViaBridge(t, "client_id=bridge_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&state=rp_state_1&nonce=nonce_1")
+	second := authorizeViaBridge(t, "client_id=bridge_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&state=rp_state_2&nonce=nonce_2")
+
+	if got := first.Query().Get("redirect_uri"); got != "http://localhost:8080/callback" {
+		t.Errorf("Expected OP redirect_uri to be the bridge callback, got %s", got)
+	}
+	if first.Query().Get("state") == "rp_state_1" || first.Query().Get("state") == second.Query().Get("state") {
+		t.Error("Expected a unique transaction ID as state sent to OP")
+	}
+
+	// 2. OP 回调桥接服务，桥接服务签发授权码并带回 RP 的原始 state
+	rpRedirect := callbackFromOP(t, "code=op_code_2&state="+url.QueryEscape(second.Query().Get("state")))
+	if rpRedirect.Host != "rp.example.com" || rpRedirect.Path != "/cb" {
+		t.Errorf("Expected redirect to RP callback, got %s", rpRedirect.String())
+	}
+	if rpRedirect.Query().Get("state") != "rp_state_2" {
+		t.Errorf("Expected original state rp_state_2, got %s", rpRedirect.Query().Get("state"))
+	}
+	code := rpRedirect.Query().Get("code")
+	if code == "" || code == "op_code_2" {
+		t.Fatalf("Expected a bridge-issued authorization code, got %q", code)
+	}
+
+	// 3. 使用桥接授权码兑换令牌，ID Token 中携带第二次登录的 nonce
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {"https://rp.example.com/cb"},
+		"client_id":    {"bridge_client"},
+	}
+	w := postTokenForm(form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp model.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+	if claims["nonce"] != "nonce_2" {
+		t.Errorf("Expected nonce nonce_2, got %v", claims["nonce"])
+	}
+
+	// 4. 授权码只能兑换一次
+	if w := postTokenForm(form); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected replayed code to be rejected with %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBridgeCallbackOPError(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().BridgeCallback = true
+	service.InitMemoryCache()
+
+	opRedirect := authorizeViaBridge(t, "client_id=bridge_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&state=rp_state")
+
+	rpRedirect := callbackFromOP(t, "error=access_denied&state="+url.QueryEscape(opRedirect.Query().Get("state")))
+	if rpRedirect.Query().Get("error") != "access_denied" {
+		t.Errorf("Expected error access_denied forwarded to RP, got %s", rpRedirect.Query().Get("error"))
+	}
+	if rpRedirect.Query().Get("state") != "rp_state" {
+		t.Errorf("Expected original state rp_state, got %s", rpRedirect.Query().Get("state"))
+	}
+}
+
+func TestBridgeCallbackUnknownState(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().BridgeCallback = true
+	service.InitMemoryCache()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/callback?code=op_code&state=unknown", nil)
+
+	handler.HandleCallback(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBridgeCodeGrantClientMismatch(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().BridgeCallback = true
+	service.InitMemoryCache()
+
+	if err := service.SaveAuthCode(config.Current(), "bridge_code", &model.AuthTransaction{
+		ClientID:    "bridge_client",
+		RedirectURI: "https://rp.example.com/cb",
+		Scope:       "openid",
+		OPCode:      "op_code",
+	}); err != nil {
+		t.Fatalf("Failed to save authorization code: %v", err)
+	}
+
+	w := postTokenForm(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"bridge_code"},
+		"redirect_uri": {"https://rp.example.com/cb"},
+		"client_id":    {"other_client"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newRecordingOP 创建记录 token 请求客户端凭据的模拟 OP
+func newRecordingOP(t *testing.T, seen *url.Values) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		*seen = url.Values{"client_id": {r.PostForm.Get("client_id")}, "client_secret": {r.PostForm.Get("client_secret")}}
+		if username, password, ok := r.BasicAuth(); ok {
+			seen.Set("basic", username+":"+password)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(model.OPTokenResponse{AccessToken: "op_access", TokenType: "Bearer", ExpiresIn: 3600})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postTokenRequest 调用 /token 处理函数，prepare 用于设置 Authorization 头
+func postTokenRequest(form url.Values, prepare func(*http.Request)) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if prepare != nil {
+		prepare(c.Request)
+	}
+	handler.HandleToken(c)
+	return w
+}
+
+// clientAssertion 签发客户端断言，aud 为测试配置的 token 端点
+func clientAssertion(t *testing.T, method jwt.SigningMethod, key interface{}, clientID, jti string) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": "http://localhost:8080/token",
+		"jti": jti,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	assertion, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign client assertion: %v", err)
+	}
+	return assertion
+}
+
+func refreshForm(extra url.Values) url.Values {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"op_refresh"}}
+	for key, values := range extra {
+		form[key] = values
+	}
+	return form
+}
+
+func TestTokenClientSecretBasic(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	var seen url.Values
+	config.Current().OPTokenURL = newRecordingOP(t, &seen).URL
+	config.Current().Clients = []model.ClientConfig{
+		{ClientID: "rp:one", ClientSecret: "secret/1", RedirectURIs: []string{"https://rp.example.com/cb"}},
+	}
+	service.InitMemoryCache()
+
+	// 1. Basic 凭据经过表单编码
+	basic := func(secret string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(url.QueryEscape("rp:one"), url.QueryEscape(secret)) }
+	}
+	if w := postTokenRequest(refreshForm(nil), basic("secret/1")); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if seen.Get("client_id") != "rp:one" || seen.Get("client_secret") != "secret/1" {
+		t.Errorf("Expected RP credentials forwarded to OP, got %v", seen)
+	}
+
+	// 2. 错误的密钥返回 401 和 WWW-Authenticate
+	w := postTokenRequest(refreshForm(nil), basic("wrong"))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected 401 with WWW-Authenticate, got %d %v", w.Code, w.Header())
+	}
+
+	// 3. 不能同时使用多种认证方式
+	if w := postTokenRequest(refreshForm(url.Values{"client_secret": {"secret/1"}}), basic("secret/1")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected multiple authentication methods to be rejected, got %d", w.Code)
+	}
+
+	// 4. 按 op_token_auth_method 以 Basic 方式向 OP 提交凭据
+	config.Current().OPTokenAuthMethod = "client_secret_basic"
+	if w := postTokenRequest(refreshForm(url.Values{"client_id": {"rp:one"}, "client_secret": {"secret/1"}}), nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if seen.Get("basic") != "rp%3Aone:secret%2F1" || seen.Get("client_secret") != "" {
+		t.Errorf("Expected form-encoded Basic credentials sent to OP, got %v", seen)
+	}
+}
+
+func TestTokenClientSecretJWT(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	var seen url.Values
+	config.Current().OPTokenURL = newRecordingOP(t, &seen).URL
+	config.Current().Clients = []model.ClientConfig{
+		{ClientID: "jwt_client", ClientSecret: "a-shared-secret-of-sufficient-length", TokenEndpointAuthMethod: "client_secret_jwt", RedirectURIs: []string{"https://rp.example.com/cb"}},
+	}
+	service.InitMemoryCache()
+
+	assertion := clientAssertion(t, jwt.SigningMethodHS256, []byte("a-shared-secret-of-sufficient-length"), "jwt_client", "jti-1")
+	form := refreshForm(url.Values{"client_assertion_type": {service.ClientAssertionTypeJWTBearer}, "client_assertion": {assertion}})
+	if w := postTokenRequest(form, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if seen.Get("client_id") != "jwt_client" || seen.Get("client_secret") != "a-shared-secret-of-sufficient-length" {
+		t.Errorf("Expected client secret forwarded to OP, got %v", seen)
+	}
+
+	// 断言不能重放，也不能改用其他认证方式
+	if w := postTokenRequest(form, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected replayed assertion to be rejected, got %d", w.Code)
+	}
+	if w := postTokenRequest(refreshForm(url.Values{"client_id": {"jwt_client"}, "client_secret": {"a-shared-secret-of-sufficient-length"}}), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected client_secret_post to be rejected for client_secret_jwt client, got %d", w.Code)
+	}
+}
+
+func TestTokenPrivateKeyJWT(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	var seen url.Values
+	config.Current().OPTokenURL = newRecordingOP(t, &seen).URL
+	service.InitMemoryCache()
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, err := service.PublicJWK(clientKey.Public(), "ES256")
+	if err != nil {
+		t.Fatalf("Failed to build client JWK: %v", err)
+	}
+	var fetches int
+	jwksServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(model.JWKS{Keys: []model.JWK{jwk}})
+	}))
+	defer jwksServer.Close()
+	defer func(original *http.Client) { service.JWKSHTTPClient = original }(service.JWKSHTTPClient)
+	service.JWKSHTTPClient = jwksServer.Client()
+
+	config.Current().Clients = []model.ClientConfig{
+		{ClientID: "inline_keys", TokenEndpointAuthMethod: "private_key_jwt", JWKS: &model.JWKS{Keys: []model.JWK{jwk}}, RedirectURIs: []string{"https://rp.example.com/cb"}},
+		{ClientID: "remote_keys", TokenEndpointAuthMethod: "private_key_jwt", JWKSURI: jwksServer.URL, RedirectURIs: []string{"https://rp.example.com/cb"}},
+	}
+
+	for _, clientID := range []string{"inline_keys", "remote_keys"} {
+		t.Run(clientID, func(t *testing.T) {
+			assertion := clientAssertion(t, jwt.SigningMethodES256, clientKey, clientID, "jti-"+clientID)
+			form := refreshForm(url.Values{"client_id": {clientID}, "client_assertion_type": {service.ClientAssertionTypeJWTBearer}, "client_assertion": {assertion}})
+			if w := postTokenRequest(form, nil); w.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			forged := clientAssertion(t, jwt.SigningMethodES256, otherKey, clientID, "jti-forged-"+clientID)
+			form.Set("client_assertion", forged)
+			if w := postTokenRequest(form, nil); w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected assertion signed by unknown key to be rejected, got %d", w.Code)
+			}
+		})
+	}
+
+	// jwks_uri 的公钥集被缓存，断言中未知的 kid 不会立即触发重新获取
+	unknownKid := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": "remote_keys", "sub": "remote_keys", "aud": "http://localhost:8080/token", "jti": "jti-unknown-kid", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	unknownKid.Header["kid"] = "rotated"
+	assertion, _ := unknownKid.SignedString(otherKey)
+	form := refreshForm(url.Values{"client_id": {"remote_keys"}, "client_assertion_type": {service.ClientAssertionTypeJWTBearer}, "client_assertion": {assertion}})
+	if w := postTokenRequest(form, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected assertion with unknown kid to be rejected, got %d", w.Code)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected jwks_uri to be fetched once, got %d", fetches)
+	}
+}
+
+func TestClientJWKSURIRestrictions(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().OPTokenURL = newRecordingOP(t, new(url.Values)).URL
+	service.InitMemoryCache()
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	oversized := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"keys":[],"padding":"` + strings.Repeat("x", 100<<10) + `"}`))
+	}))
+	defer oversized.Close()
+	defer func(original *http.Client) { service.JWKSHTTPClient = original }(service.JWKSHTTPClient)
+	service.JWKSHTTPClient = oversized.Client()
+
+	// 1. 超出大小限制的公钥集和非 https 的 jwks_uri 都不会被使用
+	config.Current().Clients = []model.ClientConfig{
+		{ClientID: "oversized_keys", TokenEndpointAuthMethod: "private_key_jwt", JWKSURI: oversized.URL},
+		{ClientID: "plain_http_keys", TokenEndpointAuthMethod: "private_key_jwt", JWKSURI: "http://127.0.0.1:1/jwks"},
+	}
+	for _, clientID := range []string{"oversized_keys", "plain_http_keys"} {
+		assertion := clientAssertion(t, jwt.SigningMethodES256, clientKey, clientID, "jti-"+clientID)
+		form := refreshForm(url.Values{"client_id": {clientID}, "client_assertion_type": {service.ClientAssertionTypeJWTBearer}, "client_assertion": {assertion}})
+		if w := postTokenRequest(form, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s to be rejected, got %d", clientID, w.Code)
+		}
+	}
+
+	// 2. 动态注册只接受 https 的 jwks_uri
+	_, err := service.RegisterClient(config.Current(), &model.ClientRegistration{
+		RedirectURIs:            []string{"https://rp.example.com/cb"},
+		TokenEndpointAuthMethod: "private_key_jwt",
+		JWKSURI:                 "http://rp.example.com/jwks",
+	})
+	var regErr *service.RegistrationError
+	if !errors.As(err, &regErr) || regErr.Code != "invalid_client_metadata" {
+		t.Errorf("Expected invalid_client_metadata for http jwks_uri, got %v", err)
+	}
+}
+
+func TestDiscoveryTokenEndpointAuthMethods(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	handler.HandleDiscovery(c)
+
+	var discovery model.Discovery
+	if err := json.Unmarshal(w.Body.Bytes(), &discovery); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	methods := strings.Join(discovery.TokenEndpointAuthMethodsSupported, " ")
+	for _, method := range []string{"client_secret_basic", "client_secret_post", "client_secret_jwt", "private_key_jwt"} {
+		if !strings.Contains(methods, method) {
+			t.Errorf("Expected %s in token_endpoint_auth_methods_supported, got %v", method, discovery.TokenEndpointAuthMethodsSupported)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newCredentialCheckingOP 创建只接受桥接服务 OP 应用凭据的模拟 OP
+func newCredentialCheckingOP(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("client_id") != "bridge_app" || r.PostForm.Get("client_secret") != "bridge_secret" {
+			_ = json.NewEncoder(w).Encode(model.OPTokenResponse{Error: "invalid_client"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(model.OPTokenResponse{AccessToken: "op_access", TokenType: "Bearer", ExpiresIn: 3600, RefreshToken: "op_refresh"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user-1"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBridgeIssuedClientCredentials(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
+		t.Skip("Private key file not found, skipping client credential tests")
+	}
+
+	op := newCredentialCheckingOP(t)
+	config.Current().OPTokenURL = op.URL + "/token"
+	config.Current().OPUserInfoURL = op.URL + "/userinfo"
+	config.Current().BridgeCallback = true
+	config.Current().OPClientID = "bridge_app"
+	config.Current().OPClientSecret = "bridge_secret"
+	config.Current().Clients = []model.ClientConfig{
+		{ClientID: "wiki", ClientSecret: "wiki_secret", RedirectURIs: []string{"https://wiki.example.com/cb"}},
+		{ClientID: "blog", ClientSecret: "blog_secret", RedirectURIs: []string{"https://blog.example.com/cb"}},
+	}
+	service.InitMemoryCache()
+
+	// 1. OP 只看到桥接服务的 client_id
+	opRedirect := authorizeViaBridge(t, "client_id=wiki&redirect_uri=https%3A%2F%2Fwiki.example.com%2Fcb&response_type=code&scope=openid")
+	if got := opRedirect.Query().Get("client_id"); got != "bridge_app" {
+		t.Errorf("Expected OP client_id bridge_app, got %s", got)
+	}
+	rpRedirect := callbackFromOP(t, "code=op_code&state="+url.QueryEscape(opRedirect.Query().Get("state")))
+	code := rpRedirect.Query().Get("code")
+
+	// 2. RP 使用桥接服务分发的凭据兑换，错误的密钥被拒绝且不消耗授权码
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://wiki.example.com/cb"},
+		"client_id":     {"wiki"},
+		"client_secret": {"blog_secret"},
+	}
+	if w := postTokenForm(form); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected wrong client_secret to be rejected with %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	form.Set("client_secret", "wiki_secret")
+	w := postTokenForm(form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp model.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+	if claims["aud"] != "wiki" {
+		t.Errorf("Expected ID token audience wiki, got %v", claims["aud"])
+	}
+
+	// 3. OP 颁发给桥接服务的 refresh_token 不能被其他 RP 使用
+	w = postTokenForm(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {resp.RefreshToken},
+		"client_id":     {"blog"},
+		"client_secret": {"blog_secret"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected refresh by another client to be rejected, got %d", w.Code)
+	}
+	w = postTokenForm(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"unknown_refresh"},
+		"client_id":     {"blog"},
+		"client_secret": {"blog_secret"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown refresh token to be rejected, got %d", w.Code)
+	}
+}
+
+func TestBridgeIssuedCredentialsPublicClient(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().BridgeCallback = true
+	config.Current().OPClientID = "bridge_app"
+	config.Current().Clients = []model.ClientConfig{
+		{ClientID: "spa", RedirectURIs: []string{"https://spa.example.com/cb"}},
+	}
+	service.InitMemoryCache()
+
+	// 公开客户端必须使用 PKCE
+	w := authorizeRequest("client_id=spa&redirect_uri=https%3A%2F%2Fspa.example.com%2Fcb&response_type=code&scope=openid&state=s")
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Host != "spa.example.com" || location.Query().Get("error") != "invalid_request" {
+		t.Errorf("Expected invalid_request redirect to RP, got %d %s", w.Code, location)
+	}
+
+	authorizeViaBridge(t, "client_id=spa&redirect_uri=https%3A%2F%2Fspa.example.com%2Fcb&response_type=code&scope=openid&code_challenge="+s256Challenge("verifier-0123456789-0123456789-0123456789")+"&code_challenge_method=S256")
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// registerTestClient 为测试配置写入只包含一个 client 的注册表
+func registerTestClient(client model.ClientConfig) {
+	config.Current().Clients = []model.ClientConfig{client}
+}
+
+// authorizeRequest 调用 /authorize 处理函数
+func authorizeRequest(rawQuery string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/authorize?"+rawQuery, nil)
+	handler.HandleAuthorize(c)
+	return w
+}
+
+func TestClientRegistryAuthorize(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	service.InitMemoryCache()
+	registerTestClient(model.ClientConfig{
+		ClientID:      "registered_client",
+		RedirectURIs:  []string{"https://rp.example.com/cb"},
+		AllowedScopes: []string{"openid", "email"},
+	})
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unknown client", "client_id=other_client&redirect_uri=https%3A%2F%2Frp.example.com%2Fcb&response_type=code&scope=openid"},
+		{"unregistered redirect_uri", "client_id=registered_client&redirect_uri=https%3A%2F%2Fevil.example.com%2Fcb&response_type=code&scope=openid"},
+		{"missing redirect_uri", "client_id=registered_client&response_type=code&scope=openid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// client_id 或 redirect_uri 无效时不能重定向
+			w := authorizeRequest(tt.query)
+			if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+				t.Fatalf("Expected 400 without redirect, got %d %s", w.Code, w.Header().Get("Location"))
+			}
+			var resp map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["error"] != "invalid_request" {
+				t.Errorf("Expected invalid_request, got %s", w.Body.String())
+			}
+		})
+	}
+
+	// 不允许的 scope 以 invalid_scope 重定向回 RP
+	w := authorizeRequest("client_id=registered_client&redirect_uri=https%3A%2F%2Frp.example.com%2Fcb&response_type=code&scope=openid+profile&state=rp_state")
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Host != "rp.example.com" {
+		t.Fatalf("Expected redirect to RP, got %d %s", w.Code, location)
+	}
+	if location.Query().Get("error") != "invalid_scope" || location.Query().Get("state") != "rp_state" {
+		t.Errorf("Expected invalid_scope with original state, got %s", location.RawQuery)
+	}
+
+	// 合法的请求照常重定向到 OP
+	w = authorizeRequest("client_id=registered_client&redirect_uri=https%3A%2F%2Frp.example.com%2Fcb&response_type=code&scope=openid+email" + testPKCEQuery)
+	location, _ = url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Host != "op.example.com" {
+		t.Errorf("Expected redirect to OP, got %d %s", w.Code, location)
+	}
+}
+
+func TestClientRegistryToken(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	service.InitMemoryCache()
+	registerTestClient(model.ClientConfig{
+		ClientID:      "registered_client",
+		RedirectURIs:  []string{"https://rp.example.com/cb"},
+		AllowedScopes: []string{"openid", "email"},
+	})
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		error  string
+	}{
+		{
+			name:   "unknown client",
+			form:   url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"rt"}, "client_id": {"other_client"}},
+			status: http.StatusUnauthorized,
+			error:  "invalid_client",
+		},
+		{
+			name:   "unregistered redirect_uri",
+			form:   url.Values{"grant_type": {"authorization_code"}, "code": {"code"}, "redirect_uri": {"https://evil.example.com/cb"}, "client_id": {"registered_client"}},
+			status: http.StatusBadRequest,
+			error:  "invalid_grant",
+		},
+		{
+			name:   "disallowed scope",
+			form:   url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"rt"}, "client_id": {"registered_client"}, "scope": {"openid profile"}},
+			status: http.StatusBadRequest,
+			error:  "invalid_scope",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postTokenForm(tt.form)
+			var resp map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if w.Code != tt.status || resp["error"] != tt.error {
+				t.Errorf("Expected %d %s, got %d %s", tt.status, tt.error, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestClientTokenLifetimeAndClaims(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
+		t.Skip("Private key file not found, skipping client ID token tests")
+	}
+	registerTestClient(model.ClientConfig{
+		ClientID:        "registered_client",
+		RedirectURIs:    []string{"https://rp.example.com/cb"},
+		IDTokenLifetime: 300,
+		Claims:          []string{"email"},
+	})
+
+	userInfo := map[string]interface{}{"sub": "user-1", "name": "Test User", "email": "test@example.com"}
+	idToken, err := service.GenerateIDToken(config.Current(), "http://localhost:8080", "registered_client", "", userInfo)
+	if err != nil {
+		t.Fatalf("Failed to generate ID token: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+
+	if lifetime := claims["exp"].(float64) - claims["iat"].(float64); lifetime != 300 {
+		t.Errorf("Expected client ID token lifetime 300, got %v", lifetime)
+	}
+	if claims["sub"] != "user-1" || claims["email"] != "test@example.com" {
+		t.Errorf("Expected sub and email claims, got %v", claims)
+	}
+	if _, ok := claims["name"]; ok {
+		t.Error("Expected name claim to be filtered out")
+	}
+}
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"oidc-bridge/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envTestConfig 环境变量覆盖测试使用的配置，SECRET_FILE 替换为密钥文件路径
+const envTestConfig = `
+op_authorize_url: "https://op.example.com/authorize"
+op_token_url: "https://op.example.com/token"
+op_userinfo_url: "https://op.example.com/userinfo"
+op_client_id: "bridge"
+op_client_secret: "${TEST_OP_CLIENT_SECRET}"
+issuer: "${TEST_ISSUER:-https://bridge.example.com}"
+id_token_lifetime: 3600
+nonce_cache_ttl: 600
+bridge_callback: true
+private_key_path: "./private.key"
+scope_mapping:
+  profile: "profile"
+user_attribute_mapping:
+  "data::open_id": "sub"
+registration:
+  enabled: true
+  initial_access_token: "${file:SECRET_FILE}"
+clients:
+  - client_id: "wiki"
+    client_secret: "literal-$${not_expanded}"
+    redirect_uris: ["https://wiki.example.com/cb"]
+providers:
+  - name: lark-cn
+    op_authorize_url: "https://lark.example.com/authorize"
+`
+
+// loadEnvTestConfig 写入配置文件和被引用的密钥文件并加载配置
+func loadEnvTestConfig(t *testing.T) error {
+	return loadEnvTestConfigText(t, envTestConfig)
+}
+
+// loadEnvTestConfigText 同 loadEnvTestConfig，使用 text 作为配置内容
+func loadEnvTestConfigText(t *testing.T, text string) error {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "initial_access_token")
+	if err := os.WriteFile(secretFile, []byte("token-from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte(strings.ReplaceAll(text, "SECRET_FILE", secretFile)), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return config.LoadConfig(configFile, "", "")
+}
+
+func TestConfigInterpolation(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	t.Setenv("TEST_OP_CLIENT_SECRET", "op-secret")
+
+	if err := loadEnvTestConfig(t); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := config.Current()
+	if cfg.OPClientSecret != "op-secret" {
+		t.Errorf("Expected ${VAR} to be expanded, got %q", cfg.OPClientSecret)
+	}
+	if cfg.Issuer != "https://bridge.example.com" {
+		t.Errorf("Expected default of unset variable, got %q", cfg.Issuer)
+	}
+	if cfg.Registration.InitialAccessToken != "token-from-file" {
+		t.Errorf("Expected ${file:...} to be expanded without trailing newline, got %q", cfg.Registration.InitialAccessToken)
+	}
+	if cfg.Clients[0].ClientSecret != "literal-${not_expanded}" {
+		t.Errorf("Expected $${ to be kept literally, got %q", cfg.Clients[0].ClientSecret)
+	}
+	if cfg.Providers[0].OPClientSecret != "op-secret" {
+		t.Errorf("Expected provider to inherit expanded value, got %q", cfg.Providers[0].OPClientSecret)
+	}
+
+	// 引用未设置的环境变量时拒绝加载
+	os.Unsetenv("TEST_OP_CLIENT_SECRET")
+	if err := loadEnvTestConfig(t); err == nil || !strings.Contains(err.Error(), "TEST_OP_CLIENT_SECRET") {
+		t.Errorf("Expected unset variable to be reported, got %v", err)
+	}
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	t.Setenv("TEST_OP_CLIENT_SECRET", "op-secret")
+	t.Setenv("OIDC_BRIDGE_ISSUER", "https://sso.example.com")
+	t.Setenv("OIDC_BRIDGE_ID_TOKEN_LIFETIME", "600")
+	t.Setenv("OIDC_BRIDGE_SCOPE_MAPPING__EMAIL", "user:email")
+	t.Setenv("OIDC_BRIDGE_USER_ATTRIBUTE_MAPPING", `{"data::user_id": "sub", "data::mail": "email"}`)
+	t.Setenv("OIDC_BRIDGE_REDIS__ADDRS", "redis-1:6379,redis-2:6379")
+	t.Setenv("OIDC_BRIDGE_PROVIDERS__LARK_CN__OP_TOKEN_URL", "https://lark.example.com/token")
+
+	if err := loadEnvTestConfig(t); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := config.Current()
+	if cfg.Issuer != "https://sso.example.com" || cfg.IDTokenLifetime != 600 {
+		t.Errorf("Expected scalar overrides, got issuer %q lifetime %d", cfg.Issuer, cfg.IDTokenLifetime)
+	}
+	if cfg.ScopeMapping["profile"] != "profile" || cfg.ScopeMapping["email"] != "user:email" {
+		t.Errorf("Expected map key override merged with file, got %v", cfg.ScopeMapping)
+	}
+	if len(cfg.AttrMapping) != 2 || cfg.AttrMapping["data::user_id"] != "sub" {
+		t.Errorf("Expected JSON map override to replace mapping, got %v", cfg.AttrMapping)
+	}
+	if len(cfg.Redis.Addrs) != 2 {
+		t.Errorf("Expected comma separated list override, got %v", cfg.Redis.Addrs)
+	}
+
+	lark := cfg.Providers[0]
+	if lark.OPTokenURL != "https://lark.example.com/token" || lark.Issuer != "https://sso.example.com" {
+		t.Errorf("Expected provider override and inherited top-level override, got %q %q", lark.OPTokenURL, lark.Issuer)
+	}
+	if cfg.OPTokenURL != "https://op.example.com/token" {
+		t.Errorf("Expected provider override not to affect top-level, got %q", cfg.OPTokenURL)
+	}
+
+	// 指向不存在的提供方时拒绝加载
+	t.Setenv("OIDC_BRIDGE_PROVIDERS__GITHUB__ISSUER", "https://github.example.com")
+	if err := loadEnvTestConfig(t); err == nil || !strings.Contains(err.Error(), "no provider named github") {
+		t.Errorf("Expected unknown provider to be reported, got %v", err)
+	}
+}
+
+func TestConfigInterpolationLiteral(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	t.Setenv("TEST_OP_CLIENT_SECRET", "op-secret")
+	t.Setenv("TEST_LITERAL", "${TEST_OP_CLIENT_SECRET}")
+
+	// 1. $${ 转义、不是变量名的 ${...} 和未闭合的 ${ 保持原样，变量的值不会再次展开
+	text := strings.Replace(envTestConfig, `"literal-$${not_expanded}"`, `"a$${B}c${!x}d${e-f}g${TEST_LITERAL}h${"`, 1)
+	if err := loadEnvTestConfigText(t, text); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if secret := config.Current().Clients[0].ClientSecret; secret != "a${B}c${!x}d${e-f}g${TEST_OP_CLIENT_SECRET}h${" {
+		t.Errorf("Expected literal ${ to be kept, got %q", secret)
+	}
+
+	// 2. 未设置的变量在错误中说明如何转义
+	text = strings.Replace(envTestConfig, `"literal-$${not_expanded}"`, `"p@ss${TEST_UNSET_SECRET}"`, 1)
+	if err := loadEnvTestConfigText(t, text); err == nil || !strings.Contains(err.Error(), "$${TEST_UNSET_SECRET}") {
+		t.Errorf("Expected error to suggest the $${ escape, got %v", err)
+	}
+}
+
+func TestConfigLegacyEnvOverridesProviders(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	t.Setenv("TEST_OP_CLIENT_SECRET", "op-secret")
+	t.Setenv("PRIVATE_KEY_PATH", "/keys/bridge.key")
+	t.Setenv("REDIS_ADDR", "redis:6379")
+
+	// 配置文件未设置 private_key_path，提供方继承 PRIVATE_KEY_PATH 后通过校验
+	text := strings.Replace(envTestConfig, "private_key_path: \"./private.key\"\n", "", 1)
+	if err := loadEnvTestConfigText(t, text); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := config.Current()
+	if cfg.PrivateKeyPath != "/keys/bridge.key" || cfg.Providers[0].PrivateKeyPath != "/keys/bridge.key" {
+		t.Errorf("Expected PRIVATE_KEY_PATH to apply to providers, got %q and %q", cfg.PrivateKeyPath, cfg.Providers[0].PrivateKeyPath)
+	}
+	if cfg.Providers[0].RedisAddr != "redis:6379" {
+		t.Errorf("Expected REDIS_ADDR to apply to providers, got %q", cfg.Providers[0].RedisAddr)
+	}
+}
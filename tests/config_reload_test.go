@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reloadConfigContent 返回热重载测试使用的配置，extra 追加在末尾
+func reloadConfigContent(keyPath, issuer, extra string) string {
+	return `
+issuer: "` + issuer + `"
+op_authorize_url: "https://op.example.com/authorize"
+op_token_url: "https://op.example.com/token"
+op_userinfo_url: "https://op.example.com/userinfo"
+id_token_lifetime: 3600
+nonce_cache_ttl: 600
+id_token_signing_alg: "ES256"
+private_key_path: "` + keyPath + `"
+user_attribute_mapping:
+  "data::user_id": "sub"
+` + extra
+}
+
+// setupReloadConfig 写入配置文件和签名密钥并加载，返回签名密钥和配置文件路径
+func setupReloadConfig(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPath := filepath.Join(dir, "signing.key")
+	writePrivateKeyFile(t, keyPath, key)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	writeConfigFile(t, configFile, reloadConfigContent(keyPath, "https://bridge.example.com", ""))
+	if err := config.LoadConfig(configFile, "", ""); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := service.InitKeyManager(); err != nil {
+		t.Fatalf("Failed to init key manager: %v", err)
+	}
+	t.Cleanup(service.CloseKeyManager)
+	return keyPath, configFile
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	keyPath, configFile := setupReloadConfig(t)
+	running := config.Current()
+
+	// 1. 合法的新配置替换当前配置，原有配置对象不被修改
+	clients := `clients:
+  - client_id: "wiki"
+    redirect_uris: ["https://wiki.example.com/cb"]
+`
+	writeConfigFile(t, configFile, reloadConfigContent(keyPath, "https://sso.example.com", clients))
+	if err := service.ReloadConfig(); err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if config.Current().Issuer != "https://sso.example.com" || len(config.Current().Clients) != 1 {
+		t.Errorf("Expected reloaded config to be in effect, got %+v", config.Current())
+	}
+	if running.Issuer != "https://bridge.example.com" {
+		t.Errorf("Expected previous config to stay unchanged, got issuer %s", running.Issuer)
+	}
+
+	// 2. 不合法的配置被拒绝，保留正在使用的配置
+	reloaded := config.Current()
+	invalid := map[string]string{
+		"client registry": reloadConfigContent(keyPath, "https://sso.example.com", `clients:
+  - client_id: "wiki"
+`),
+		"missing signing key": reloadConfigContent(keyPath+".missing", "https://sso.example.com", ""),
+		"unparsable yaml":     reloadConfigContent(keyPath, "https://sso.example.com", "clients: [\n"),
+	}
+	for name, content := range invalid {
+		writeConfigFile(t, configFile, content)
+		if err := service.ReloadConfig(); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+		if config.Current() != reloaded {
+			t.Errorf("Expected running config to be kept after rejected reload (%s)", name)
+		}
+	}
+}
+
+func TestConfigReloadRequiresRestartForPrefixedProviders(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	keyPath, configFile := setupReloadConfig(t)
+
+	providers := `providers:
+  - name: lark
+    op_authorize_url: "https://lark.example.com/authorize"
+`
+	writeConfigFile(t, configFile, reloadConfigContent(keyPath, "https://bridge.example.com", providers))
+	if err := service.ReloadConfig(); err == nil || !strings.Contains(err.Error(), "restart") {
+		t.Errorf("Expected new path_prefix provider to require restart, got %v", err)
+	}
+	if len(config.Current().Providers) != 0 {
+		t.Error("Expected running config to be kept")
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	old := &model.Config{
+		Issuer:         "https://bridge.example.com",
+		OPClientSecret: "secret",
+		ScopeMapping:   map[string]string{"profile": "user:read"},
+		Providers:      []*model.Config{{Name: "lark", IDTokenLifetime: 3600}},
+	}
+	cfg := &model.Config{
+		Issuer:         "https://bridge.example.com",
+		OPClientSecret: "rotated",
+		ScopeMapping:   map[string]string{"profile": "user:read", "email": "user:email"},
+		Providers:      []*model.Config{{Name: "lark", IDTokenLifetime: 600}},
+		Clients:        []model.ClientConfig{{ClientID: "wiki"}},
+	}
+
+	changed := strings.Join(config.Diff(old, cfg), " ")
+	expected := "clients op_client_secret providers.lark.id_token_lifetime scope_mapping.email"
+	if changed != expected {
+		t.Errorf("Expected changed keys %q, got %q", expected, changed)
+	}
+	if diff := config.Diff(cfg, cfg); len(diff) != 0 {
+		t.Errorf("Expected no changes, got %v", diff)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	keyPath, configFile := setupReloadConfig(t)
+
+	changed := make(chan struct{}, 1)
+	stop, err := config.WatchConfig(func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to watch config: %v", err)
+	}
+	defer stop()
+
+	// 同一目录下的其他文件变化不触发重载
+	writeConfigFile(t, filepath.Join(filepath.Dir(configFile), "other.yaml"), "issuer: ignored\n")
+	writeConfigFile(t, configFile, reloadConfigContent(keyPath, "https://sso.example.com", ""))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected config change to be detected")
+	}
+}
+
+func TestProviderSnapshotPerRequest(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	r := setupProviders(t)
+
+	// 处理请求期间替换配置，正在处理的请求继续使用原有的提供方配置
+	r.Group("/lark", handler.UseProvider("lark")).GET("/snapshot", func(c *gin.Context) {
+		reloaded := *config.Current()
+		reloaded.Providers = nil
+		config.SetCurrent(&reloaded)
+		handler.HandleDiscovery(c)
+	})
+	w := serveProvider(r, httptest.NewRequest("GET", "http://bridge.example.com/lark/snapshot", nil))
+	var discovery model.Discovery
+	if err := json.Unmarshal(w.Body.Bytes(), &discovery); err != nil || discovery.Issuer != "http://bridge.example.com/lark" {
+		t.Errorf("Expected request to keep provider lark, got %d %s", w.Code, w.Body.String())
+	}
+
+	// 新的请求使用替换后的配置
+	if w := serveProvider(r, httptest.NewRequest("GET", "/lark/.well-known/openid-configuration", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected removed provider to be unavailable for new requests, got %d", w.Code)
+	}
+}
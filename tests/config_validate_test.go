@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validTestConfig 返回一份能通过校验的配置
+func validTestConfig() *model.Config {
+	return &model.Config{
+		OPAuthURL:       "https://op.example.com/authorize?app_id={client_id}",
+		OPTokenURL:      "https://op.example.com/token",
+		OPUserInfoURL:   "https://op.example.com/userinfo",
+		Issuer:          "https://bridge.example.com",
+		IDTokenLifetime: 3600,
+		NonceCacheTTL:   600,
+		SigningAlg:      "ES256",
+		PrivateKeyPath:  "./private.key",
+		AttrMapping:     map[string]string{"data::open_id": "sub", "data::email": "email"},
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := config.Validate(validTestConfig()); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(cfg *model.Config)
+		problem string
+	}{
+		{"missing token url", func(cfg *model.Config) { cfg.OPTokenURL = "" }, "op_token_url is required"},
+		{"relative url", func(cfg *model.Config) { cfg.OPUserInfoURL = "/userinfo" }, "op_userinfo_url \"/userinfo\" must be an absolute http(s) URL"},
+		{"issuer with query", func(cfg *model.Config) { cfg.Issuer = "https://bridge.example.com?x=1" }, "issuer"},
+		{"zero lifetime", func(cfg *model.Config) { cfg.IDTokenLifetime = 0 }, "id_token_lifetime must be a positive number"},
+		{"negative ttl", func(cfg *model.Config) { cfg.RefreshTokenTTL = -1 }, "refresh_token_ttl must not be negative"},
+		{"unsupported alg", func(cfg *model.Config) { cfg.SigningAlg = "HS256" }, "unsupported id_token_signing_alg \"HS256\""},
+		{"no sub mapping", func(cfg *model.Config) { delete(cfg.AttrMapping, "data::open_id") }, "must map exactly one OP attribute to sub"},
+		{"two sub mappings", func(cfg *model.Config) { cfg.AttrMapping["data::union_id"] = "sub" }, "maps data::open_id and data::union_id to claim sub"},
+		{"no signing key", func(cfg *model.Config) { cfg.PrivateKeyPath = "" }, "private_key_path is required"},
+		{"bad not_before", func(cfg *model.Config) {
+			cfg.SigningKeys = []model.SigningKeyConfig{{PrivateKeyPath: "a.key", NotBefore: "2024-01-01"}}
+		}, "not_before \"2024-01-01\" is not an RFC 3339 time"},
+		{"sql without dsn", func(cfg *model.Config) { cfg.Store = "sql"; cfg.SQL.Driver = "sqlite" }, "sql.dsn is required"},
+		{"client redirect uri", func(cfg *model.Config) {
+			cfg.Clients = []model.ClientConfig{{ClientID: "wiki", RedirectURIs: []string{"/cb"}}}
+		}, "client wiki: redirect_uri \"/cb\" must be an absolute URI"},
+		{"plain http jwks_uri", func(cfg *model.Config) {
+			cfg.Clients = []model.ClientConfig{{ClientID: "reports", JWKSURI: "http://reports.example.com/jwks.json"}}
+		}, "client reports: jwks_uri \"http://reports.example.com/jwks.json\" must be an absolute https URL"},
+		{"registration without op_client_id", func(cfg *model.Config) {
+			cfg.BridgeCallback = true
+			cfg.Registration = model.RegistrationConfig{Enabled: true, InitialAccessToken: "secret"}
+		}, "registration requires op_client_id"},
+		{"registration without bridge callback", func(cfg *model.Config) {
+			cfg.Registration = model.RegistrationConfig{Enabled: true, InitialAccessToken: "secret"}
+		}, "registration requires bridge_callback"},
+		{"token request encoding", func(cfg *model.Config) { cfg.OPTokenRequest.Encoding = "xml" }, "unsupported op_token_request.encoding \"xml\""},
+		{"authorize request headers", func(cfg *model.Config) {
+			cfg.OPAuthorizeRequest.Headers = map[string]string{"x-tenant": "a"}
+		}, "op_authorize_request only supports params and extra_params"},
+		{"extra param without value", func(cfg *model.Config) {
+			cfg.OPTokenRequest.ExtraParams = []string{"appType"}
+		}, "op_token_request.extra_params entry \"appType\" must have the form name=value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			tt.mutate(cfg)
+			err := config.Validate(cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("Expected problem %q, got %v", tt.problem, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigReportsAllProblems(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.OPAuthURL = ""
+	lark := validTestConfig()
+	lark.Name = "lark"
+	lark.NonceCacheTTL = 0
+	cfg.Providers = []*model.Config{lark}
+
+	err := config.Validate(cfg)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	// 顶层配置没有 OP 地址时不对外提供服务，只报告提供方的问题
+	if len(validationErr.Problems) != 1 || validationErr.Problems[0] != "provider lark: nonce_cache_ttl must be a positive number of seconds, got 0" {
+		t.Errorf("Unexpected problems: %v", validationErr.Problems)
+	}
+
+	cfg.OPAuthURL = "https://op.example.com/authorize"
+	cfg.OPTokenURL = ""
+	if err := config.Validate(cfg); !errors.As(err, &validationErr) || len(validationErr.Problems) != 2 {
+		t.Errorf("Expected problems of both configs, got %v", err)
+	}
+}
+
+func TestCheckSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	writePrivateKeyFile(t, filepath.Join(dir, "signing.key"), key)
+
+	cfg := validTestConfig()
+	cfg.PrivateKeyPath = filepath.Join(dir, "signing.key")
+	if err := service.CheckSigningKeys(cfg); err != nil {
+		t.Errorf("Expected EC key to match ES256, got %v", err)
+	}
+
+	// 密钥类型与签名算法不匹配
+	cfg.SigningAlg = "RS256"
+	if err := service.CheckSigningKeys(cfg); err == nil {
+		t.Error("Expected EC key to be rejected for RS256")
+	}
+
+	// 缺失的密钥文件只有在开启 auto_generate_key 时才能通过
+	cfg.PrivateKeyPath = filepath.Join(dir, "missing.key")
+	if err := service.CheckSigningKeys(cfg); err == nil {
+		t.Error("Expected missing key to be rejected")
+	}
+	cfg.AutoGenerateKey = true
+	if err := service.CheckSigningKeys(cfg); err != nil {
+		t.Errorf("Expected missing key with auto_generate_key to pass, got %v", err)
+	}
+}
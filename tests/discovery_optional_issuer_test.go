@@ -22,8 +22,8 @@ func init() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 }
@@ -43,7 +43,7 @@ func TestHandleDiscovery(t *testing.T) {
 	}
 
 	// 验证关键字段
-	expectedIssuer := config.AppConfig.Issuer
+	expectedIssuer := config.Current().Issuer
 	if expectedIssuer == "" {
 		expectedIssuer = "http://example.com"
 	}
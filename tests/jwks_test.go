@@ -15,11 +15,11 @@ import (
 
 func init() {
 	// 确保密钥文件存在
-	if _, err := os.Stat(config.AppConfig.PrivateKeyPath); os.IsNotExist(err) {
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
 		os.Exit(0)
 	}
 
-	if _, err := os.Stat(config.AppConfig.PublicKeyPath); os.IsNotExist(err) {
+	if _, err := os.Stat(config.Current().PublicKeyPath); os.IsNotExist(err) {
 		os.Exit(0)
 	}
 }
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"oidc-bridge/config"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyManagerCachesAndReloadsKeys(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().SigningAlg = "ES256"
+
+	dir := t.TempDir()
+	config.Current().PrivateKeyPath = filepath.Join(dir, "private.key")
+	// 未配置 public_key_path 时公钥由私钥推导
+	config.Current().PublicKeyPath = ""
+
+	firstKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writePrivateKeyFile(t, config.Current().PrivateKeyPath, firstKey)
+
+	if err := service.InitKeyManager(); err != nil {
+		t.Fatalf("Failed to init key manager: %v", err)
+	}
+	defer service.CloseKeyManager()
+
+	kids := fetchJWKSKids(t)
+	if len(kids) != 1 || !kids[keyID(t, firstKey)] {
+		t.Errorf("Expected derived public key in JWKS, got %v", kids)
+	}
+
+	// 1. 密钥加载后缓存在内存中，不再每次读取文件
+	if err := os.Rename(config.Current().PrivateKeyPath, filepath.Join(t.TempDir(), "moved.key")); err != nil {
+		t.Fatalf("Failed to move private key: %v", err)
+	}
+	if kid := signingKid(t); kid != keyID(t, firstKey) {
+		t.Errorf("Expected cached key to sign, got kid %s", kid)
+	}
+
+	// 2. 密钥文件变化后自动重新加载
+	secondKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writePrivateKeyFile(t, config.Current().PrivateKeyPath, secondKey)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for signingKid(t) != keyID(t, secondKey) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected key manager to reload changed private key")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestKeyManagerValidatesAtStartup(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().SigningAlg = "ES256"
+
+	dir := t.TempDir()
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writeTestKeyPair(t, otherKey)
+	config.Current().PrivateKeyPath = filepath.Join(dir, "private.key")
+	writePrivateKeyFile(t, config.Current().PrivateKeyPath, privateKey)
+
+	// 每个步骤在前一步的基础上修改配置，均应在启动时报错
+	steps := []struct {
+		name  string
+		apply func()
+	}{
+		{"public key mismatch", func() {}},
+		{"algorithm mismatch", func() {
+			config.Current().PublicKeyPath = ""
+			config.Current().SigningAlg = "RS256"
+		}},
+		{"invalid PEM", func() {
+			config.Current().SigningAlg = "ES256"
+			if err := os.WriteFile(config.Current().PrivateKeyPath, []byte("not a key"), 0600); err != nil {
+				t.Fatalf("Failed to write private key: %v", err)
+			}
+		}},
+		{"missing key", func() {
+			config.Current().PrivateKeyPath = filepath.Join(dir, "missing.key")
+		}},
+	}
+
+	for _, step := range steps {
+		step.apply()
+		if err := service.InitKeyManager(); err == nil {
+			service.CloseKeyManager()
+			t.Errorf("Expected startup error for %s", step.name)
+		}
+	}
+}
@@ -19,20 +19,20 @@ func init() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 }
 
 func TestLoadPrivateKey(t *testing.T) {
 	// 确保密钥文件存在
-	if _, err := os.Stat(config.AppConfig.PrivateKeyPath); os.IsNotExist(err) {
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
 		t.Skip("Private key file not found, skipping private key loading tests")
 	}
 
 	// 调用函数
-	key, err := service.LoadPrivateKey()
+	key, err := service.LoadPrivateKey(config.Current())
 	if err != nil {
 		t.Errorf("Failed to load private key: %v", err)
 	}
@@ -44,12 +44,12 @@ func TestLoadPrivateKey(t *testing.T) {
 
 func TestLoadPublicKey(t *testing.T) {
 	// 确保密钥文件存在
-	if _, err := os.Stat(config.AppConfig.PublicKeyPath); os.IsNotExist(err) {
+	if _, err := os.Stat(config.Current().PublicKeyPath); os.IsNotExist(err) {
 		t.Skip("Public key file not found, skipping public key loading tests")
 	}
 
 	// 调用函数
-	key, err := service.LoadPublicKey()
+	key, err := service.LoadPublicKey(config.Current())
 	if err != nil {
 		t.Errorf("Failed to load public key: %v", err)
 	}
@@ -1,7 +1,11 @@
 package tests
 
 import (
+	"errors"
+	"fmt"
 	"oidc-bridge/service"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -33,3 +37,167 @@ func TestMemoryCache(t *testing.T) {
 		t.Error("Expected cache item to not exist")
 	}
 }
+
+func TestMemoryCacheLRUEviction(t *testing.T) {
+	cache := service.NewBoundedMemoryCache(3, 0)
+
+	// 1. 超出最大项数时淘汰最近最少使用的缓存项
+	cache.Set("a", "1", time.Minute)
+	cache.Set("b", "2", time.Minute)
+	cache.Set("c", "3", time.Minute)
+	cache.Get("a")
+	cache.Set("d", "4", time.Minute)
+
+	if _, exists := cache.Get("b"); exists {
+		t.Error("Expected least recently used item to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+
+	// 2. 统计信息
+	stats := cache.Stats()
+	if stats.Entries != 3 || stats.Evictions != 1 {
+		t.Errorf("Expected 3 entries and 1 eviction, got %+v", stats)
+	}
+	if stats.Hits != 4 || stats.Misses != 1 {
+		t.Errorf("Expected 4 hits and 1 miss, got %+v", stats)
+	}
+}
+
+func TestMemoryCacheByteBudget(t *testing.T) {
+	value := strings.Repeat("x", 1000)
+	cache := service.NewBoundedMemoryCache(0, 5000)
+
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), value, time.Minute)
+	}
+
+	stats := cache.Stats()
+	if stats.Bytes > 5000 {
+		t.Errorf("Expected cache to stay within byte budget, got %d bytes", stats.Bytes)
+	}
+	if stats.Entries == 0 || stats.Evictions == 0 {
+		t.Errorf("Expected entries and evictions, got %+v", stats)
+	}
+	if _, exists := cache.Get("key-19"); !exists {
+		t.Error("Expected most recent item to be kept")
+	}
+
+	// 删除后释放预算
+	for i := 0; i < 20; i++ {
+		cache.Delete(fmt.Sprintf("key-%d", i))
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected empty cache after delete, got %+v", stats)
+	}
+}
+
+func TestMemoryCachePinnedEntries(t *testing.T) {
+	cache := service.NewBoundedMemoryCache(10, 5000)
+
+	// 1. 永不过期的缓存项和防重放标记不受容量限制影响
+	cache.Set("signing_key", "pem", 0)
+	cache.Set("redeemed:abc", "1", time.Minute)
+	cache.Set("assertion:abc", "1", time.Minute)
+	cache.Set("refresh:abc", "record", time.Hour)
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("txn:%d", i), strings.Repeat("x", 100), time.Minute)
+	}
+	for _, key := range []string{"signing_key", "redeemed:abc", "assertion:abc", "refresh:abc"} {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("Expected %s to survive the flood", key)
+		}
+	}
+	if ok, _ := cache.SetNX("redeemed:abc", "1", time.Minute); ok {
+		t.Error("Expected replay marker to still block SetNX")
+	}
+
+	// 2. 可淘汰的缓存项仍在容量限制内
+	stats := cache.Stats()
+	if stats.Entries > 14 || stats.Evictions == 0 {
+		t.Errorf("Expected at most 10 transient entries plus 4 pinned ones, got %+v", stats)
+	}
+
+	// 3. 过期的防重放标记照常清理
+	cache.Set("redeemed:short", "1", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	cache.ClearExpired()
+	if _, exists := cache.Get("redeemed:short"); exists {
+		t.Error("Expected expired replay marker to be cleared")
+	}
+}
+
+func TestMemoryCachePinnedLimit(t *testing.T) {
+	cache := service.NewBoundedMemoryCache(10, 0)
+	cache.LimitPinned(3, 0)
+
+	// 1. 不会被淘汰的缓存项达到上限后写入失败，已有的标记不受影响
+	for i := 0; i < 3; i++ {
+		if err := cache.Set(fmt.Sprintf("redeemed:%d", i), "1", 50*time.Millisecond); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if ok, err := cache.SetNX("redeemed:flood", "1", time.Minute); ok || !errors.Is(err, service.ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull, got %v %v", ok, err)
+	}
+	if err := cache.Set("signing_key", "pem", 0); !errors.Is(err, service.ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull for entry without expiry, got %v", err)
+	}
+	if _, exists := cache.Get("redeemed:0"); !exists {
+		t.Error("Expected existing replay marker to be kept")
+	}
+
+	// 2. 覆盖已有的项和可淘汰的项不受限制
+	if err := cache.Set("redeemed:0", "1", 50*time.Millisecond); err != nil {
+		t.Errorf("Expected overwrite to succeed, got %v", err)
+	}
+	if err := cache.Set("txn:1", "value", time.Minute); err != nil {
+		t.Errorf("Expected transient entry to be stored, got %v", err)
+	}
+
+	// 3. 过期的项清理后可以再次写入
+	time.Sleep(time.Second + 50*time.Millisecond)
+	if ok, err := cache.SetNX("redeemed:flood", "1", time.Minute); !ok || err != nil {
+		t.Errorf("Expected SetNX to succeed after markers expired, got %v %v", ok, err)
+	}
+}
+
+func TestMemoryCacheExpiration(t *testing.T) {
+	cache := service.NewMemoryCache()
+	cache.Set("short", "value", 10*time.Millisecond)
+	cache.Set("long", "value", time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	cache.ClearExpired()
+	stats := cache.Stats()
+	if stats.Entries != 1 || stats.Expirations != 1 {
+		t.Errorf("Expected 1 entry and 1 expiration, got %+v", stats)
+	}
+}
+
+func TestMemoryCacheConcurrentAccess(t *testing.T) {
+	// 并发读写过期项，配合 go test -race 检查锁的使用
+	cache := service.NewBoundedMemoryCache(50, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("key-%d", j%100)
+				cache.Set(key, "value", time.Millisecond)
+				cache.Get(key)
+				cache.Take(key)
+				cache.Incr(fmt.Sprintf("counter-%d", i), time.Minute)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := cache.Stats(); stats.Entries > 50 {
+		t.Errorf("Expected at most 50 entries, got %d", stats.Entries)
+	}
+}
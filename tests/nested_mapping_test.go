@@ -26,7 +26,7 @@ func TestNestedMappingConfig(t *testing.T) {
 
 	// 检查映射是否正确
 	for nestedKey, expectedValue := range expectedMappings {
-		if actualValue, exists := config.AppConfig.AttrMapping[nestedKey]; !exists {
+		if actualValue, exists := config.Current().AttrMapping[nestedKey]; !exists {
 			t.Errorf("Expected nested mapping key '%s' not found", nestedKey)
 		} else if actualValue != expectedValue {
 			t.Errorf("Expected nested mapping for key '%s' to be '%s', got '%s'", nestedKey, expectedValue, actualValue)
@@ -34,7 +34,7 @@ func TestNestedMappingConfig(t *testing.T) {
 	}
 
 	// 验证普通映射仍然有效
-	if actualValue, exists := config.AppConfig.AttrMapping["sub"]; !exists {
+	if actualValue, exists := config.Current().AttrMapping["sub"]; !exists {
 		t.Error("Expected mapping key 'sub' not found")
 	} else if actualValue != "sub" {
 		t.Errorf("Expected mapping for key 'sub' to be 'sub', got '%s'", actualValue)
@@ -60,7 +60,7 @@ func TestStandardMappingConfig(t *testing.T) {
 
 	// 检查映射是否正确
 	for key, expectedValue := range expectedMappings {
-		if actualValue, exists := config.AppConfig.AttrMapping[key]; !exists {
+		if actualValue, exists := config.Current().AttrMapping[key]; !exists {
 			t.Errorf("Expected mapping key '%s' not found", key)
 		} else if actualValue != expectedValue {
 			t.Errorf("Expected mapping for key '%s' to be '%s', got '%s'", key, expectedValue, actualValue)
@@ -90,7 +90,7 @@ func TestNestedMappingFunctionality(t *testing.T) {
 
 	// 验证嵌套属性映射功能
 	mappedUserInfo := make(map[string]interface{})
-	for opAttr, oidcClaim := range config.AppConfig.AttrMapping {
+	for opAttr, oidcClaim := range config.Current().AttrMapping {
 		if value, ok := service.GetNestedValue(userInfo, opAttr); ok {
 			mappedUserInfo[oidcClaim] = value
 		}
@@ -132,7 +132,7 @@ func TestStandardMappingFunctionality(t *testing.T) {
 
 	// 验证标准属性映射功能
 	mappedUserInfo := make(map[string]interface{})
-	for opAttr, oidcClaim := range config.AppConfig.AttrMapping {
+	for opAttr, oidcClaim := range config.Current().AttrMapping {
 		if value, ok := service.GetNestedValue(userInfo, opAttr); ok {
 			mappedUserInfo[oidcClaim] = value
 		}
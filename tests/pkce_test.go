@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+// testPKCEQuery 透传模式下授权请求必须携带的 PKCE 参数
+var testPKCEQuery = "&code_challenge=" + s256Challenge(testCodeVerifier) + "&code_challenge_method=S256"
+
+// s256Challenge 计算 code_verifier 对应的 S256 code_challenge
+func s256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// RFC 7636 附录 B 的示例
+	if !service.VerifyCodeChallenge("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", service.PKCEMethodS256, testCodeVerifier) {
+		t.Error("Expected S256 verifier to match RFC 7636 example challenge")
+	}
+
+	if !service.VerifyCodeChallenge(testCodeVerifier, service.PKCEMethodPlain, testCodeVerifier) {
+		t.Error("Expected plain verifier to match identical challenge")
+	}
+
+	if service.VerifyCodeChallenge(s256Challenge(testCodeVerifier), service.PKCEMethodS256, strings.Repeat("a", 43)) {
+		t.Error("Expected mismatched verifier to be rejected")
+	}
+
+	if service.VerifyCodeChallenge("short", service.PKCEMethodPlain, "short") {
+		t.Error("Expected verifier shorter than 43 characters to be rejected")
+	}
+}
+
+func TestNormalizeCodeChallenge(t *testing.T) {
+	challenge := s256Challenge(testCodeVerifier)
+
+	if method, err := service.NormalizeCodeChallenge(challenge, ""); err != nil || method != service.PKCEMethodPlain {
+		t.Errorf("Expected default method plain, got %s (err: %v)", method, err)
+	}
+
+	if _, err := service.NormalizeCodeChallenge(challenge, "S512"); err == nil {
+		t.Error("Expected error for unsupported code_challenge_method")
+	}
+
+	if _, err := service.NormalizeCodeChallenge("invalid challenge", service.PKCEMethodS256); err == nil {
+		t.Error("Expected error for malformed code_challenge")
+	}
+}
+
+func TestHandleAuthorizePKCEEnforcedByBridge(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().OPSupportsPKCE = false
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	challenge := s256Challenge(testCodeVerifier)
+	c.Request = &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "client_id=pkce_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&code_challenge=" + challenge + "&code_challenge_method=S256",
+		},
+	}
+
+	service.InitMemoryCache()
+
+	handler.HandleAuthorize(c)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusFound, w.Code, w.Body.String())
+	}
+
+	// OP 不支持 PKCE 时不应把 code_challenge 转发给 OP
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if location.Query().Get("code_challenge") != "" {
+		t.Error("code_challenge should not be forwarded to OP without PKCE support")
+	}
+
+	// code_challenge 应随授权上下文保存，由桥接服务在 /token 时校验
+	txn, err := service.TakePendingTransaction("", testCodeVerifier)
+	if err != nil {
+		t.Fatalf("Expected pending transaction, got error: %v", err)
+	}
+	if txn.CodeChallenge != challenge || txn.CodeChallengeMethod != service.PKCEMethodS256 {
+		t.Errorf("Expected stored S256 challenge %s, got %s %s", challenge, txn.CodeChallengeMethod, txn.CodeChallenge)
+	}
+}
+
+func TestHandleAuthorizePKCEForwardedToOP(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().OPSupportsPKCE = true
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	challenge := s256Challenge(testCodeVerifier)
+	c.Request = &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "client_id=pkce_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&code_challenge=" + challenge + "&code_challenge_method=S256",
+		},
+	}
+
+	service.InitMemoryCache()
+
+	handler.HandleAuthorize(c)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusFound, w.Code, w.Body.String())
+	}
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if location.Query().Get("code_challenge") != challenge {
+		t.Errorf("Expected code_challenge %s forwarded to OP, got %s", challenge, location.Query().Get("code_challenge"))
+	}
+	if location.Query().Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected code_challenge_method S256, got %s", location.Query().Get("code_challenge_method"))
+	}
+}
+
+func TestHandleAuthorizeInvalidCodeChallengeMethod(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request = &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "client_id=pkce_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid&code_challenge=" + s256Challenge(testCodeVerifier) + "&code_challenge_method=S512",
+		},
+	}
+
+	service.InitMemoryCache()
+
+	handler.HandleAuthorize(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandleTokenPKCEMismatch(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().OPSupportsPKCE = false
+
+	service.InitMemoryCache()
+	if err := service.SavePendingTransaction(config.Current(), s256Challenge(testCodeVerifier), service.PKCEMethodS256, &model.AuthTransaction{
+		ClientID:            "pkce_client",
+		RedirectURI:         "https://example.com/callback",
+		Scope:               "openid",
+		CodeChallenge:       s256Challenge(testCodeVerifier),
+		CodeChallengeMethod: service.PKCEMethodS256,
+	}); err != nil {
+		t.Fatalf("Failed to save pending transaction: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", "test_code")
+	form.Set("redirect_uri", "https://example.com/callback")
+	form.Set("client_id", "pkce_client")
+	form.Set("code_verifier", strings.Repeat("a", 43))
+	c.Request = httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler.HandleToken(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if body["error"] != "invalid_grant" {
+		t.Errorf("Expected error invalid_grant, got %s", body["error"])
+	}
+}
+
+func TestHandleTokenPKCEContextMissing(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().OPSupportsPKCE = false
+	service.InitMemoryCache()
+
+	// 1. 授权时携带了 code_challenge，授权上下文在兑换前过期
+	authorizePassthrough(t, "client_id=pkce_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid", testCodeVerifier)
+	if _, err := service.TakePendingTransaction("", testCodeVerifier); err != nil {
+		t.Fatalf("Expected pending transaction, got error: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", "test_code")
+	form.Set("redirect_uri", "https://example.com/callback")
+	form.Set("client_id", "pkce_client")
+	if w := postTokenForm(form); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant without code_verifier, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 2. 桥接回调模式下，公开客户端的授权码没有保存 code_challenge 时同样拒绝
+	config.Current().BridgeCallback = true
+	config.Current().OPClientID = "bridge_op_client"
+	registerTestClient(model.ClientConfig{ClientID: "pkce_client", RedirectURIs: []string{"https://example.com/callback"}})
+	if err := service.SaveAuthCode(config.Current(), "bridge_code", &model.AuthTransaction{
+		ClientID:    "pkce_client",
+		RedirectURI: "https://example.com/callback",
+		Scope:       "openid",
+		Provider:    config.Current().Name,
+	}); err != nil {
+		t.Fatalf("Failed to save authorization code: %v", err)
+	}
+	form.Set("code", "bridge_code")
+	if w := postTokenForm(form); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant for public client without code_challenge, got %d: %s", w.Code, w.Body.String())
+	}
+}
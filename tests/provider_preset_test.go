@@ -0,0 +1,279 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// presetTestConfig 预设测试使用的配置，只包含与 OP 无关的设置
+const presetTestConfig = `
+issuer: "https://bridge.example.com"
+id_token_lifetime: 3600
+nonce_cache_ttl: 600
+private_key_path: "./private.key"
+`
+
+// loadPresetTestConfig 在 presetTestConfig 之后追加 extra 并加载配置
+func loadPresetTestConfig(t *testing.T, extra string) error {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(presetTestConfig+extra), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return config.LoadConfig(configFile, "", "")
+}
+
+func TestProviderPreset(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	err := loadPresetTestConfig(t, `
+provider: github
+scope_mapping:
+  email: "user:email read:org"
+user_attribute_mapping:
+  "node_id": "sub"
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := config.Current()
+	if cfg.OPTokenURL != "https://github.com/login/oauth/access_token" || cfg.OPUserInfoURL != "https://api.github.com/user" {
+		t.Errorf("Expected GitHub endpoints from preset, got %q %q", cfg.OPTokenURL, cfg.OPUserInfoURL)
+	}
+	if cfg.ScopeMapping["profile"] != "read:user" || cfg.ScopeMapping["email"] != "user:email read:org" {
+		t.Errorf("Expected scope mapping merged with preset, got %v", cfg.ScopeMapping)
+	}
+	// 配置文件映射到 sub 的属性替换预设的 id
+	if _, ok := cfg.AttrMapping["id"]; ok || cfg.AttrMapping["node_id"] != "sub" || cfg.AttrMapping["login"] != "preferred_username" {
+		t.Errorf("Expected attribute mapping merged by claim, got %v", cfg.AttrMapping)
+	}
+
+	// 未知的预设拒绝加载
+	if err := loadPresetTestConfig(t, "provider: gitea\n"); err == nil || !strings.Contains(err.Error(), "use one of dingtalk, github, gitlab, lark, slack, wecom") {
+		t.Errorf("Expected unknown preset to be reported, got %v", err)
+	}
+
+	// 企业微信需要在授权地址中补充 agentid
+	if err := loadPresetTestConfig(t, "provider: wecom\n"); err == nil || !strings.Contains(err.Error(), "agentid") {
+		t.Errorf("Expected missing agentid to be reported, got %v", err)
+	}
+}
+
+func TestProviderPresetPerProvider(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	err := loadPresetTestConfig(t, `
+provider: lark
+op_client_id: "cli_lark"
+op_client_secret: "lark_secret"
+bridge_callback: true
+clients:
+  - client_id: "wiki"
+    redirect_uris: ["https://wiki.example.com/cb"]
+providers:
+  - name: lark-cn
+  - name: gitlab
+    provider: gitlab
+    op_client_id: "gitlab_app"
+    op_authorize_url: "https://gitlab.example.com/oauth/authorize"
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	larkCN, gitlab := config.Current().Providers[0], config.Current().Providers[1]
+
+	// 未选择预设的提供方继承顶层配置及其预设
+	if larkCN.OPTokenURL != "https://open.feishu.cn/open-apis/authen/v2/oauth/token" || larkCN.AttrMapping["data::open_id"] != "sub" {
+		t.Errorf("Expected lark-cn to inherit the top-level preset, got %q %v", larkCN.OPTokenURL, larkCN.AttrMapping)
+	}
+
+	// 选择了自己预设的提供方不继承顶层预设的 OP 设置，其余设置照常继承
+	if gitlab.OPAuthURL != "https://gitlab.example.com/oauth/authorize" || gitlab.OPTokenURL != "https://gitlab.com/oauth/token" {
+		t.Errorf("Expected gitlab preset with overridden authorize URL, got %q %q", gitlab.OPAuthURL, gitlab.OPTokenURL)
+	}
+	if _, ok := gitlab.AttrMapping["data::open_id"]; ok || gitlab.AttrMapping["id"] != "sub" {
+		t.Errorf("Expected gitlab attribute mapping only, got %v", gitlab.AttrMapping)
+	}
+	if _, ok := gitlab.ScopeMapping["email"]; !ok || gitlab.ScopeMapping["email"] != "" {
+		t.Errorf("Expected gitlab scope mapping, got %v", gitlab.ScopeMapping)
+	}
+	if !gitlab.OPSupportsPKCE || gitlab.OPClientID != "gitlab_app" || gitlab.OPClientSecret != "lark_secret" || len(gitlab.Clients) != 1 {
+		t.Errorf("Expected gitlab to inherit non-preset settings, got %+v", gitlab)
+	}
+}
+
+func TestPresetRequiredScopes(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if err := loadPresetTestConfig(t, "provider: dingtalk\n"); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	service.InitMemoryCache()
+
+	opRedirect := authorizeViaBridge(t, "client_id=ding_app&redirect_uri=https%3A%2F%2Fwiki.example.com%2Fcb&response_type=code&scope=openid%20profile&state=xyz"+testPKCEQuery)
+	// openid 由 op_required_scopes 转发，profile 映射为空被丢弃；授权地址中固定的 prompt 被保留
+	if got := opRedirect.Query().Get("scope"); got != "openid" {
+		t.Errorf("Expected only the required scope, got %q", got)
+	}
+	if got := opRedirect.Query().Get("prompt"); got != "consent" {
+		t.Errorf("Expected prompt from op_authorize_url to be kept, got %q", got)
+	}
+}
+
+func TestNumericSubject(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": 12345678, "login": "octocat"}`))
+	}))
+	defer op.Close()
+
+	cfg := &model.Config{OPUserInfoURL: op.URL, AttrMapping: map[string]string{"id": "sub", "login": "preferred_username"}}
+	userInfo, err := service.GetUserInfoFromOP(cfg, "gho_token")
+	if err != nil {
+		t.Fatalf("Failed to get user info: %v", err)
+	}
+	if userInfo["sub"] != "12345678" {
+		t.Errorf("Expected numeric id converted to string sub, got %#v", userInfo["sub"])
+	}
+}
+
+func TestDingTalkDialect(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.0/oauth2/userAccessToken", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		if body["clientId"] != "ding_app" || body["clientSecret"] != "ding_secret" || body["code"] != "ding_code" || body["grantType"] != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code": "invalidParameter", "message": "bad request"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"accessToken": "ding_access", "refreshToken": "ding_refresh", "expireIn": 7200}`))
+	})
+	mux.HandleFunc("/v1.0/contact/users/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("x-acs-dingtalk-access-token") != "ding_access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code": "InvalidAuthentication", "message": "invalid token"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"nick": "Ding", "unionId": "union-1", "openId": "open-1", "avatarUrl": "https://img.example.com/a.png"}`))
+	})
+	op := httptest.NewServer(mux)
+	defer op.Close()
+
+	err := loadPresetTestConfig(t, "provider: dingtalk\nop_token_url: \""+op.URL+"/v1.0/oauth2/userAccessToken\"\nop_userinfo_url: \""+op.URL+"/v1.0/contact/users/me\"\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := config.Current()
+
+	req := model.TokenRequest{GrantType: "authorization_code", Code: "ding_code", ClientID: "ding_app", ClientSecret: "ding_secret"}
+	opResp, err := service.ProxyToOPTokenEndpoint(cfg, req)
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	if opResp.AccessToken != "ding_access" || opResp.RefreshToken != "ding_refresh" || opResp.ExpiresIn != 7200 {
+		t.Errorf("Expected camelCase response converted, got %+v", opResp)
+	}
+
+	userInfo, err := service.GetUserInfoFromOP(cfg, opResp.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to get user info: %v", err)
+	}
+	if userInfo["sub"] != "union-1" || userInfo["name"] != "Ding" || userInfo["picture"] != "https://img.example.com/a.png" {
+		t.Errorf("Expected DingTalk attributes mapped, got %v", userInfo)
+	}
+
+	// 钉钉的错误转换为 OAuth2 错误
+	req.Code = "expired"
+	if opResp, err := service.ProxyToOPTokenEndpoint(cfg, req); err != nil || opResp.Error != "invalid_grant" {
+		t.Errorf("Expected invalid_grant, got %+v, %v", opResp, err)
+	}
+}
+
+func TestWeComDialect(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	tokenRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/gettoken", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("corpid") != "ww_corp" || r.URL.Query().Get("corpsecret") != "corp_secret" {
+			_, _ = w.Write([]byte(`{"errcode": 40001, "errmsg": "invalid secret"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errcode": 0, "errmsg": "ok", "access_token": "app_token", "expires_in": 7200}`))
+	})
+	mux.HandleFunc("/cgi-bin/auth/getuserinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Query().Get("access_token") != "app_token":
+			_, _ = w.Write([]byte(`{"errcode": 40014, "errmsg": "invalid access_token"}`))
+		case r.URL.Query().Get("code") == "member_code":
+			_, _ = w.Write([]byte(`{"errcode": 0, "errmsg": "ok", "userid": "zhangsan", "user_ticket": "ticket"}`))
+		case r.URL.Query().Get("code") == "guest_code":
+			_, _ = w.Write([]byte(`{"errcode": 0, "errmsg": "ok", "openid": "guest"}`))
+		default:
+			_, _ = w.Write([]byte(`{"errcode": 40029, "errmsg": "invalid code"}`))
+		}
+	})
+	op := httptest.NewServer(mux)
+	defer op.Close()
+
+	err := loadPresetTestConfig(t, "provider: wecom\nop_authorize_url: \"https://login.work.weixin.qq.com/wwlogin/sso/login?login_type=CorpApp&agentid=1000002\"\n"+
+		"op_token_url: \""+op.URL+"/cgi-bin/gettoken\"\nop_userinfo_url: \""+op.URL+"/cgi-bin/auth/getuserinfo\"\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := config.Current()
+	service.InitMemoryCache()
+
+	// 1. 授权请求以 appid 传递企业 ID
+	opRedirect := authorizeViaBridge(t, "client_id=ww_corp&redirect_uri=https%3A%2F%2Fwiki.example.com%2Fcb&response_type=code&scope=openid&state=xyz"+testPKCEQuery)
+	if opRedirect.Query().Get("appid") != "ww_corp" || opRedirect.Query().Get("client_id") != "" || opRedirect.Query().Get("agentid") != "1000002" {
+		t.Errorf("Expected appid and agentid in WeCom login URL, got %s", opRedirect.RawQuery)
+	}
+
+	// 2. 授权码换取成员身份，返回给 RP 的不是应用 access_token
+	req := model.TokenRequest{GrantType: "authorization_code", Code: "member_code", ClientID: "ww_corp", ClientSecret: "corp_secret"}
+	opResp, err := service.ProxyToOPTokenEndpoint(cfg, req)
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	if opResp.AccessToken == "" || opResp.AccessToken == "app_token" {
+		t.Fatalf("Expected a bridge-issued access token, got %+v", opResp)
+	}
+	userInfo, err := service.GetUserInfoFromOP(cfg, opResp.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to get user info: %v", err)
+	}
+	if userInfo["sub"] != "zhangsan" {
+		t.Errorf("Expected UserID as sub, got %v", userInfo)
+	}
+	if _, err := service.GetUserInfoFromOP(cfg, "app_token"); err == nil {
+		t.Error("Expected unknown access token to be rejected")
+	}
+
+	// 3. 应用 access_token 被缓存，无效的授权码和非企业成员被拒绝
+	for code, expected := range map[string]string{"bad_code": "invalid_grant", "guest_code": "access_denied"} {
+		req.Code = code
+		if opResp, err := service.ProxyToOPTokenEndpoint(cfg, req); err != nil || opResp.Error != expected {
+			t.Errorf("Expected %s for %s, got %+v, %v", expected, code, opResp, err)
+		}
+	}
+	if tokenRequests > 1 {
+		t.Errorf("Expected app access token to be cached, fetched %d times", tokenRequests)
+	}
+}
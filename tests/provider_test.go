@@ -0,0 +1,254 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupProviders 写入包含两个提供方的配置并加载，返回注册了所有提供方路由的 gin 引擎
+func setupProviders(t *testing.T) *gin.Engine {
+	dir := t.TempDir()
+	for _, name := range []string{"lark", "internal"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		writePrivateKeyFile(t, filepath.Join(dir, name+".key"), key)
+	}
+
+	configFile := filepath.Join(dir, "config.yaml")
+	content := `
+id_token_lifetime: 3600
+nonce_cache_ttl: 600
+id_token_signing_alg: "ES256"
+bridge_callback: true
+scope_mapping:
+  profile: "profile"
+user_attribute_mapping:
+  open_id: "sub"
+providers:
+  - name: lark
+    op_authorize_url: "https://lark.example.com/authorize"
+    op_token_url: "https://lark.example.com/token"
+    op_userinfo_url: "https://lark.example.com/userinfo"
+    private_key_path: "` + filepath.Join(dir, "lark.key") + `"
+    scope_mapping:
+      contact: "contact:user"
+  - name: internal
+    host: sso.internal.example.com
+    issuer: "https://sso.internal.example.com"
+    op_authorize_url: "https://oauth.internal.example.com/authorize"
+    op_token_url: "https://oauth.internal.example.com/token"
+    op_userinfo_url: "https://oauth.internal.example.com/userinfo"
+    private_key_path: "` + filepath.Join(dir, "internal.key") + `"
+`
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := config.LoadConfig(configFile, "", ""); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	r := gin.New()
+	handler.RegisterProviders(r)
+	return r
+}
+
+func serveProvider(r *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoadProviders(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	setupProviders(t)
+
+	providers := config.Current().Providers
+	if len(providers) != 2 {
+		t.Fatalf("Expected 2 providers, got %d", len(providers))
+	}
+	lark, internal := providers[0], providers[1]
+
+	// 未设置的键继承顶层配置，设置的键整体替换顶层的值
+	if lark.PathPrefix != "/lark" || internal.PathPrefix != "" {
+		t.Errorf("Unexpected path prefixes: %q, %q", lark.PathPrefix, internal.PathPrefix)
+	}
+	if lark.IDTokenLifetime != 3600 || lark.SigningAlg != "ES256" || !lark.BridgeCallback {
+		t.Errorf("Expected provider to inherit top-level settings, got %+v", lark)
+	}
+	if _, ok := lark.ScopeMapping["profile"]; ok || lark.ScopeMapping["contact"] != "contact:user" {
+		t.Errorf("Expected provider scope_mapping to replace top-level mapping, got %v", lark.ScopeMapping)
+	}
+	if internal.ScopeMapping["profile"] != "profile" {
+		t.Errorf("Expected internal provider to inherit scope_mapping, got %v", internal.ScopeMapping)
+	}
+
+	// 顶层配置没有 OP 地址，不作为默认提供方
+	if configs := config.ProviderConfigs(config.Current()); len(configs) != 2 {
+		t.Errorf("Expected 2 served configs, got %d", len(configs))
+	}
+}
+
+func TestProviderRouting(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	r := setupProviders(t)
+	if err := service.InitKeyManager(); err != nil {
+		t.Fatalf("Failed to init key manager: %v", err)
+	}
+	defer service.CloseKeyManager()
+	service.InitMemoryCache()
+
+	// 1. 每个提供方有自己的 Issuer 和发现文档
+	req := httptest.NewRequest("GET", "http://bridge.example.com/lark/.well-known/openid-configuration", nil)
+	var discovery model.Discovery
+	if err := json.Unmarshal(serveProvider(r, req).Body.Bytes(), &discovery); err != nil {
+		t.Fatalf("Failed to parse discovery: %v", err)
+	}
+	if discovery.Issuer != "http://bridge.example.com/lark" || discovery.TokenEndpoint != "http://bridge.example.com/lark/token" {
+		t.Errorf("Unexpected lark discovery: %+v", discovery)
+	}
+
+	req = httptest.NewRequest("GET", "http://sso.internal.example.com:8080/.well-known/openid-configuration", nil)
+	if err := json.Unmarshal(serveProvider(r, req).Body.Bytes(), &discovery); err != nil {
+		t.Fatalf("Failed to parse discovery: %v", err)
+	}
+	if discovery.Issuer != "https://sso.internal.example.com" {
+		t.Errorf("Unexpected internal issuer: %s", discovery.Issuer)
+	}
+
+	// 2. 每个提供方发布自己的签名密钥
+	kids := make(map[string]string)
+	for name, target := range map[string]string{
+		"lark":     "http://bridge.example.com/lark/.well-known/jwks.json",
+		"internal": "http://sso.internal.example.com/.well-known/jwks.json",
+	} {
+		var jwks model.JWKS
+		if err := json.Unmarshal(serveProvider(r, httptest.NewRequest("GET", target, nil)).Body.Bytes(), &jwks); err != nil || len(jwks.Keys) != 1 {
+			t.Fatalf("Unexpected JWKS for %s: %v (err: %v)", name, jwks, err)
+		}
+		kids[name] = jwks.Keys[0].Kid
+	}
+	if kids["lark"] == kids["internal"] {
+		t.Error("Expected providers to publish different keys")
+	}
+
+	// 3. 授权请求重定向到各自的 OP，并使用各自的 scope 映射
+	query := "?response_type=code&client_id=rp&redirect_uri=https%3A%2F%2Frp.example.com%2Fcb&scope=openid+contact"
+	w := serveProvider(r, httptest.NewRequest("GET", "http://bridge.example.com/lark/authorize"+query, nil))
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Host != "lark.example.com" {
+		t.Fatalf("Expected redirect to lark OP, got %d %s", w.Code, location)
+	}
+	if location.Query().Get("scope") != "contact:user" {
+		t.Errorf("Expected lark scope mapping, got %q", location.Query().Get("scope"))
+	}
+	if location.Query().Get("redirect_uri") != "http://bridge.example.com/lark/callback" {
+		t.Errorf("Expected provider callback URL, got %q", location.Query().Get("redirect_uri"))
+	}
+
+	w = serveProvider(r, httptest.NewRequest("GET", "http://sso.internal.example.com/authorize"+query, nil))
+	location, _ = url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Host != "oauth.internal.example.com" {
+		t.Errorf("Expected redirect to internal OP, got %d %s", w.Code, location)
+	}
+
+	// 4. 根路径下未知的主机名没有对应的提供方
+	w = serveProvider(r, httptest.NewRequest("GET", "http://unknown.example.com/.well-known/openid-configuration", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown host, got %d", w.Code)
+	}
+}
+
+func TestProviderCodeIsolation(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	r := setupProviders(t)
+	service.InitMemoryCache()
+
+	// lark 签发的授权码不能在 internal 兑换
+	if err := service.SaveAuthCode(config.Current(), "lark_code", &model.AuthTransaction{
+		ClientID:    "rp",
+		RedirectURI: "https://rp.example.com/cb",
+		Scope:       "openid",
+		Provider:    "lark",
+		OPCode:      "op_code",
+	}); err != nil {
+		t.Fatalf("Failed to save authorization code: %v", err)
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"lark_code"},
+		"redirect_uri": {"https://rp.example.com/cb"},
+		"client_id":    {"rp"},
+	}
+	req := httptest.NewRequest("POST", "http://sso.internal.example.com/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := serveProvider(r, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant, got %d %s", w.Code, w.Body.String())
+	}
+
+	// 被拒绝的授权码未被消费，仍可在 lark 兑换
+	if txn, err := service.TakeAuthCode("lark", "lark_code"); err != nil || txn.OPCode != "op_code" {
+		t.Errorf("Expected authorization code to be kept for lark, got %+v (err: %v)", txn, err)
+	}
+
+	// 透传模式的授权上下文同样按提供方隔离
+	if err := service.SavePendingTransaction(config.Current(), s256Challenge(testCodeVerifier), service.PKCEMethodS256, &model.AuthTransaction{ClientID: "rp", Provider: "lark"}); err != nil {
+		t.Fatalf("Failed to save pending transaction: %v", err)
+	}
+	if _, err := service.TakePendingTransaction("internal", testCodeVerifier); err == nil {
+		t.Error("Expected pending transaction of lark to be invisible to internal")
+	}
+	if _, err := service.TakePendingTransaction("lark", testCodeVerifier); err != nil {
+		t.Errorf("Expected pending transaction to be kept for lark, got %v", err)
+	}
+}
+
+func TestProviderTransactionTTL(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	r := setupProviders(t)
+	service.InitMemoryCache()
+
+	// 只在提供方中设置的保存时长生效，顶层未设置时记录也会过期
+	config.Current().NonceCacheTTL = 0
+	lark := config.Current().Providers[0]
+	lark.NonceCacheTTL = 1
+
+	query := "?response_type=code&client_id=rp&redirect_uri=https%3A%2F%2Frp.example.com%2Fcb&scope=openid"
+	w := serveProvider(r, httptest.NewRequest("GET", "http://bridge.example.com/lark/authorize"+query, nil))
+	location, _ := url.Parse(w.Header().Get("Location"))
+	state := location.Query().Get("state")
+	if w.Code != http.StatusFound || state == "" {
+		t.Fatalf("Expected redirect to lark OP, got %d %s", w.Code, location)
+	}
+	if claimed, err := service.ClaimAuthCode(lark, "ttl_code"); err != nil || !claimed {
+		t.Fatalf("Failed to claim authorization code: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	w = serveProvider(r, httptest.NewRequest("GET", "http://bridge.example.com/lark/callback?code=op_code&state="+state, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected transaction to expire after the provider nonce_cache_ttl, got %d %s", w.Code, w.Body.String())
+	}
+	if claimed, err := service.ClaimAuthCode(lark, "ttl_code"); err != nil || !claimed {
+		t.Errorf("Expected redeemed marker to expire after the provider nonce_cache_ttl, got %v (err: %v)", claimed, err)
+	}
+}
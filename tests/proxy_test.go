@@ -18,8 +18,8 @@ func init() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 }
@@ -37,7 +37,7 @@ func TestProxyToOPTokenEndpoint(t *testing.T) {
 	// 调用函数
 	// 注意：由于我们没有实际的 OP 服务，这里会返回错误
 	// 但我们仍然可以验证处理逻辑是否正确执行
-	_, err := service.ProxyToOPTokenEndpoint(req)
+	_, err := service.ProxyToOPTokenEndpoint(config.Current(), req)
 	if err == nil {
 		t.Error("Expected error due to no real OP service, got nil")
 	}
@@ -47,7 +47,7 @@ func TestGetUserInfoFromOP(t *testing.T) {
 	// 调用函数
 	// 注意：由于我们没有实际的 OP 服务，这里会返回错误
 	// 但我们仍然可以验证处理逻辑是否正确执行
-	_, err := service.GetUserInfoFromOP("test_token")
+	_, err := service.GetUserInfoFromOP(config.Current(), "test_token")
 	if err == nil {
 		t.Error("Expected error due to no real OP service, got nil")
 	}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestNewRedisClientModes(t *testing.T) {
+	cases := []struct {
+		name      string
+		cfg       *model.Config
+		isCluster bool
+	}{
+		{"legacy redis_addr", &model.Config{RedisAddr: "localhost:6379"}, false},
+		{"single node", &model.Config{Redis: model.RedisConfig{Addrs: []string{"redis:6379"}, Username: "bridge", Password: "secret", DB: 2}}, false},
+		{"sentinel", &model.Config{Redis: model.RedisConfig{Addrs: []string{"sentinel-1:26379", "sentinel-2:26379"}, MasterName: "mymaster"}}, false},
+		{"cluster", &model.Config{Redis: model.RedisConfig{Addrs: []string{"node-1:6379", "node-2:6379", "node-3:6379"}}}, true},
+	}
+
+	for _, tc := range cases {
+		client, err := service.NewRedisClient(tc.cfg)
+		if err != nil {
+			t.Errorf("%s: failed to create client: %v", tc.name, err)
+			continue
+		}
+		_, isCluster := client.(*redis.ClusterClient)
+		if isCluster != tc.isCluster {
+			t.Errorf("%s: expected cluster client %v, got %T", tc.name, tc.isCluster, client)
+		}
+		client.Close()
+	}
+}
+
+// writeTestCertificate 生成自签名证书和私钥，用作 CA 和客户端证书
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "redis-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestNewRedisClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	cfg := &model.Config{Redis: model.RedisConfig{
+		Addrs: []string{"redis:6380"},
+		TLS: model.RedisTLSConfig{
+			Enabled:    true,
+			CAFile:     certFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "redis.internal",
+		},
+	}}
+	client, err := service.NewRedisClient(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create TLS client: %v", err)
+	}
+	tlsConfig := client.(*redis.Client).Options().TLSConfig
+	if tlsConfig == nil || tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 || tlsConfig.ServerName != "redis.internal" {
+		t.Errorf("Unexpected TLS config: %+v", tlsConfig)
+	}
+	client.Close()
+
+	// CA 文件不存在时报错
+	cfg.Redis.TLS.CAFile = filepath.Join(dir, "missing.pem")
+	if _, err := service.NewRedisClient(cfg); err == nil {
+		t.Error("Expected error for missing CA file")
+	}
+}
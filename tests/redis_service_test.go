@@ -4,6 +4,7 @@ import (
 	"oidc-bridge/config"
 	"oidc-bridge/model"
 	"oidc-bridge/service"
+	"strings"
 	"testing"
 
 	"github.com/go-redis/redis/v8"
@@ -19,45 +20,68 @@ func init() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 
 	// 初始化 Redis 客户端
 	service.RedisClient = redis.NewClient(&redis.Options{
-		Addr: config.AppConfig.RedisAddr,
+		Addr: config.Current().RedisAddr,
 	})
 }
 
-func TestSetAndGetNonce(t *testing.T) {
+func TestSaveAndTakePendingTransaction(t *testing.T) {
 	// 设置测试数据
 	clientID := "test_client"
 	redirectURI := "https://example.com/callback"
 	nonce := "test_nonce"
 
-	// 调用 SetNonce
-	err := service.SetNonce(clientID, redirectURI, nonce)
+	// 保存授权上下文
+	err := service.SavePendingTransaction(config.Current(), s256Challenge(testCodeVerifier), service.PKCEMethodS256, &model.AuthTransaction{
+		ClientID:    clientID,
+		RedirectURI: redirectURI,
+		Scope:       "openid",
+		Nonce:       nonce,
+	})
 	if err != nil {
-		t.Errorf("Failed to set nonce: %v", err)
+		t.Errorf("Failed to save pending transaction: %v", err)
 	}
 
-	// 调用 GetNonce
-	retrievedNonce, err := service.GetNonce(clientID, redirectURI)
+	// 取出授权上下文
+	txn, err := service.TakePendingTransaction("", testCodeVerifier)
 	if err != nil {
-		t.Errorf("Failed to get nonce: %v", err)
+		t.Fatalf("Failed to take pending transaction: %v", err)
 	}
 
 	// 验证结果
-	if retrievedNonce != nonce {
-		t.Errorf("Expected nonce %s, got %s", nonce, retrievedNonce)
+	if txn.Nonce != nonce {
+		t.Errorf("Expected nonce %s, got %s", nonce, txn.Nonce)
+	}
+
+	// 授权上下文只能取出一次
+	if _, err := service.TakePendingTransaction("", testCodeVerifier); err == nil {
+		t.Error("Expected error for consumed pending transaction, got nil")
 	}
 }
 
-func TestGetNonceNotFound(t *testing.T) {
-	// 使用不存在的键调用 GetNonce
-	_, err := service.GetNonce("nonexistent_client", "https://example.com/callback")
+func TestTakePendingTransactionNotFound(t *testing.T) {
+	// 使用没有对应授权的 code_verifier 取出授权上下文
+	_, err := service.TakePendingTransaction("", strings.Repeat("b", 43))
 	if err == nil {
-		t.Error("Expected error for nonexistent nonce, got nil")
+		t.Error("Expected error for nonexistent pending transaction, got nil")
+	}
+}
+
+func TestClaimAuthCode(t *testing.T) {
+	// 同一授权码只能标记一次
+	claimed, err := service.ClaimAuthCode(config.Current(), "claim_test_code")
+	if err != nil || !claimed {
+		t.Fatalf("Expected first claim to succeed, got %v (err: %v)", claimed, err)
+	}
+
+	claimed, err = service.ClaimAuthCode(config.Current(), "claim_test_code")
+	if err != nil || claimed {
+		t.Errorf("Expected second claim to fail, got %v (err: %v)", claimed, err)
 	}
 }
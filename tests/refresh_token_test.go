@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newFakeOP 启动一个模拟的 OAuth2 OP，支持 authorization_code 和 refresh_token 两种授权方式
+func newFakeOP(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			_ = json.NewEncoder(w).Encode(model.OPTokenResponse{AccessToken: "op_access_1", TokenType: "Bearer", ExpiresIn: 3600, RefreshToken: "op_refresh_1"})
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != "op_refresh_1" {
+				_ = json.NewEncoder(w).Encode(model.OPTokenResponse{Error: "invalid_grant"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(model.OPTokenResponse{AccessToken: "op_access_2", TokenType: "Bearer", ExpiresIn: 3600, RefreshToken: "op_refresh_2"})
+		default:
+			_ = json.NewEncoder(w).Encode(model.OPTokenResponse{Error: "unsupported_grant_type"})
+		}
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user-1", "name": "Test User", "email": "test@example.com"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postTokenForm 以表单方式调用 /token 处理函数
+func postTokenForm(form url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler.HandleToken(c)
+	return w
+}
+
+func TestHandleTokenRefreshGrant(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
+		t.Skip("Private key file not found, skipping refresh token tests")
+	}
+
+	op := newFakeOP(t)
+	config.Current().OPTokenURL = op.URL + "/token"
+	config.Current().OPUserInfoURL = op.URL + "/userinfo"
+	service.InitMemoryCache()
+
+	// 1. 授权码换取令牌，记录 refresh_token 对应的 subject
+	authorizePassthrough(t, "client_id=refresh_client&redirect_uri=https://example.com/callback&response_type=code&scope=openid+profile", testCodeVerifier)
+	w := postTokenForm(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"test_code"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"client_id":     {"refresh_client"},
+		"code_verifier": {testCodeVerifier},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// 2. 使用 refresh_token 刷新，应重新签发 ID Token
+	w = postTokenForm(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"op_refresh_1"},
+		"client_id":     {"refresh_client"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp model.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.AccessToken != "op_access_2" || resp.RefreshToken != "op_refresh_2" {
+		t.Errorf("Expected refreshed OP tokens, got access_token %s, refresh_token %s", resp.AccessToken, resp.RefreshToken)
+	}
+	if resp.IDToken == "" {
+		t.Fatal("Expected ID token on refresh, got empty")
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Errorf("Expected sub user-1, got %v", claims["sub"])
+	}
+	if claims["aud"] != "refresh_client" {
+		t.Errorf("Expected aud refresh_client, got %v", claims["aud"])
+	}
+	if _, ok := claims["nonce"]; ok {
+		t.Error("Refreshed ID token should not contain nonce")
+	}
+
+	// 3. 授权信息应迁移到轮换后的 refresh_token
+	if record, _ := service.GetRefreshToken("op_refresh_1"); record != nil {
+		t.Error("Expected rotated refresh token record to be deleted")
+	}
+	if record, _ := service.GetRefreshToken("op_refresh_2"); record == nil || record.Subject != "user-1" {
+		t.Error("Expected refresh token record to move to the new refresh token")
+	}
+}
+
+func TestHandleTokenRefreshGrantWrongClient(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	service.InitMemoryCache()
+	if err := service.SaveRefreshToken(config.Current(), "op_refresh_1", &model.RefreshTokenRecord{Subject: "user-1", ClientID: "refresh_client"}); err != nil {
+		t.Fatalf("Failed to save refresh token record: %v", err)
+	}
+
+	w := postTokenForm(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"op_refresh_1"},
+		"client_id":     {"other_client"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if body["error"] != "invalid_grant" {
+		t.Errorf("Expected error invalid_grant, got %s", body["error"])
+	}
+}
+
+func TestHandleTokenUnsupportedGrantType(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	w := postTokenForm(url.Values{
+		"grant_type": {"password"},
+		"client_id":  {"test_client"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registrationRequest 以 JSON 请求体调用注册端点
+func registrationRequest(r *gin.Engine, method, target, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, target, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestClientRegistrationDisabled(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	r := gin.New()
+	handler.RegisterProviders(r)
+
+	w := registrationRequest(r, "POST", "http://bridge.example.com/register", "token", model.ClientRegistration{RedirectURIs: []string{"https://rp.example.com/cb"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestClientRegistrationMetadataRestrictions(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	service.InitMemoryCache()
+
+	tests := []struct {
+		name     string
+		metadata model.ClientRegistration
+		code     string
+	}{
+		{"plain http", model.ClientRegistration{RedirectURIs: []string{"http://wiki.example.com/cb"}}, "invalid_redirect_uri"},
+		{"custom scheme", model.ClientRegistration{RedirectURIs: []string{"com.example.app:/cb"}}, "invalid_redirect_uri"},
+		{"client_secret_jwt", model.ClientRegistration{RedirectURIs: []string{"https://wiki.example.com/cb"}, TokenEndpointAuthMethod: "client_secret_jwt"}, "invalid_client_metadata"},
+		{"loopback http", model.ClientRegistration{RedirectURIs: []string{"http://127.0.0.1:8400/cb", "http://localhost/cb", "http://[::1]/cb"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.RegisterClient(config.Current(), &tt.metadata)
+			var regErr *service.RegistrationError
+			if tt.code == "" && err != nil {
+				t.Errorf("Expected registration to succeed, got %v", err)
+			}
+			if tt.code != "" && (!errors.As(err, &regErr) || regErr.Code != tt.code) {
+				t.Errorf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestClientRegistrationLifecycle(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	config.Current().Issuer = "http://bridge.example.com"
+	config.Current().Registration = model.RegistrationConfig{Enabled: true, InitialAccessToken: "initial-token"}
+	service.InitMemoryCache()
+	r := gin.New()
+	handler.RegisterProviders(r)
+
+	// 1. 发现文档公布注册端点
+	var discovery model.Discovery
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "http://bridge.example.com/.well-known/openid-configuration", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &discovery); err != nil || discovery.RegistrationEndpoint != "http://bridge.example.com/register" {
+		t.Errorf("Expected registration_endpoint in discovery, got %q (err: %v)", discovery.RegistrationEndpoint, err)
+	}
+
+	// 2. 注册请求必须携带初始访问令牌，元数据必须合法
+	metadata := model.ClientRegistration{ClientName: "Wiki", RedirectURIs: []string{"https://wiki.example.com/cb"}, Scope: "openid email"}
+	if w := registrationRequest(r, "POST", "http://bridge.example.com/register", "wrong-token", metadata); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected invalid initial access token to be rejected, got %d", w.Code)
+	}
+	invalid := model.ClientRegistration{RedirectURIs: []string{"/relative"}}
+	if w := registrationRequest(r, "POST", "http://bridge.example.com/register", "initial-token", invalid); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid redirect_uri to be rejected, got %d", w.Code)
+	}
+
+	w = registrationRequest(r, "POST", "http://bridge.example.com/register", "initial-token", metadata)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var registration model.ClientRegistration
+	if err := json.Unmarshal(w.Body.Bytes(), &registration); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if registration.ClientID == "" || registration.ClientSecret == "" || registration.RegistrationAccessToken == "" {
+		t.Fatalf("Expected issued credentials, got %+v", registration)
+	}
+	if registration.RegistrationClientURI != "http://bridge.example.com/register/"+registration.ClientID {
+		t.Errorf("Unexpected registration_client_uri: %s", registration.RegistrationClientURI)
+	}
+
+	// 3. 注册后的客户端可以发起授权，scope 受注册时的 scope 限制
+	query := "client_id=" + url.QueryEscape(registration.ClientID) + "&redirect_uri=https%3A%2F%2Fwiki.example.com%2Fcb&response_type=code"
+	if w := authorizeRequest(query + "&scope=openid+email" + testPKCEQuery); w.Code != http.StatusFound || bytes.Contains([]byte(w.Header().Get("Location")), []byte("error")) {
+		t.Errorf("Expected registered client to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+	if w := authorizeRequest(query + "&scope=openid+profile"); w.Code != http.StatusFound || !bytes.Contains([]byte(w.Header().Get("Location")), []byte("invalid_scope")) {
+		t.Errorf("Expected invalid_scope redirect, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// 4. 使用注册访问令牌读取和更新元数据
+	clientURI := registration.RegistrationClientURI
+	if w := registrationRequest(r, "GET", clientURI, "wrong-token", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected invalid registration access token to be rejected, got %d", w.Code)
+	}
+	if w := registrationRequest(r, "GET", clientURI, registration.RegistrationAccessToken, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	update := model.ClientRegistration{ClientID: registration.ClientID, RedirectURIs: []string{"https://wiki.example.com/new-cb"}}
+	w = registrationRequest(r, "PUT", clientURI, registration.RegistrationAccessToken, update)
+	var updated model.ClientRegistration
+	if err := json.Unmarshal(w.Body.Bytes(), &updated); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected update to succeed, got %d %s", w.Code, w.Body.String())
+	}
+	if updated.ClientSecret != "" {
+		t.Error("Expected client_secret not to be returned after registration")
+	}
+
+	// client_secret 只保存摘要，更新后原密钥仍然有效
+	stored, _ := service.GlobalStore.Get("client:" + registration.ClientID)
+	if stored == "" || bytes.Contains([]byte(stored), []byte(registration.ClientSecret)) {
+		t.Errorf("Expected only the client_secret digest to be stored, got %s", stored)
+	}
+	client, err := service.LookupClient(config.Current(), registration.ClientID)
+	if err != nil {
+		t.Fatalf("Failed to load registered client: %v", err)
+	}
+	creds := service.ClientCredentials{ClientID: registration.ClientID, ClientSecret: registration.ClientSecret, Method: service.AuthMethodClientSecretBasic}
+	if err := service.AuthenticateClient(client, creds, nil); err != nil {
+		t.Errorf("Expected issued client_secret to authenticate, got %v", err)
+	}
+	creds.ClientSecret = "wrong-secret"
+	if err := service.AuthenticateClient(client, creds, nil); err == nil {
+		t.Error("Expected wrong client_secret to be rejected")
+	}
+	update.ClientSecret = "wrong-secret"
+	if w := registrationRequest(r, "PUT", clientURI, registration.RegistrationAccessToken, update); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected update with wrong client_secret to be rejected, got %d", w.Code)
+	}
+	if w := authorizeRequest(query + "&scope=openid"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected old redirect_uri to be rejected after update, got %d", w.Code)
+	}
+
+	// 5. 注销后客户端不能再使用
+	if w := registrationRequest(r, "DELETE", clientURI, registration.RegistrationAccessToken, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := registrationRequest(r, "GET", clientURI, registration.RegistrationAccessToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected deleted client to be unavailable, got %d", w.Code)
+	}
+	if w := authorizeRequest("client_id=" + url.QueryEscape(registration.ClientID) + "&redirect_uri=https%3A%2F%2Fwiki.example.com%2Fnew-cb&response_type=code&scope=openid"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected deleted client to be rejected, got %d", w.Code)
+	}
+}
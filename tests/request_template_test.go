@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oidc-bridge/config"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"testing"
+)
+
+func TestOPTokenRequestTemplate(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	var body map[string]string
+	var tenant string
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("X-Tenant")
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected JSON request, got %s", r.Header.Get("Content-Type"))
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(model.OPTokenResponse{AccessToken: "op_access", TokenType: "Bearer", ExpiresIn: 3600})
+	}))
+	defer op.Close()
+
+	cfg := config.Current()
+	cfg.OPTokenURL = op.URL
+	cfg.OPTokenRequest = model.OPRequestConfig{
+		Encoding:    "json",
+		Params:      map[string]string{"client_id": "app_id", "client_secret": "app_secret", "redirect_uri": ""},
+		ExtraParams: []string{"appType=web"},
+		Headers:     map[string]string{"x-tenant": "tenant-{client_id}"},
+	}
+
+	req := model.TokenRequest{GrantType: "authorization_code", Code: "op_code", RedirectURI: "https://rp.example.com/cb", ClientID: "rp", ClientSecret: "rp_secret"}
+	opResp, err := service.ProxyToOPTokenEndpoint(cfg, req)
+	if err != nil || opResp.AccessToken != "op_access" {
+		t.Fatalf("Failed to exchange code: %+v, %v", opResp, err)
+	}
+
+	expected := map[string]string{"grant_type": "authorization_code", "code": "op_code", "app_id": "rp", "app_secret": "rp_secret", "appType": "web"}
+	if len(body) != len(expected) {
+		t.Errorf("Expected body %v, got %v", expected, body)
+	}
+	for name, value := range expected {
+		if body[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, body[name])
+		}
+	}
+	if tenant != "tenant-rp" {
+		t.Errorf("Expected header with expanded placeholder, got %q", tenant)
+	}
+}
+
+func TestOPAuthorizeRequestTemplate(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	service.InitMemoryCache()
+
+	cfg := config.Current()
+	cfg.OPAuthURL = "https://open.feishu.cn/open-apis/authen/v1/index?redirect_uri={redirect_uri}&app_id={client_id}"
+	cfg.OPAuthorizeRequest = model.OPRequestConfig{
+		Params:      map[string]string{"client_id": "", "response_type": ""},
+		ExtraParams: []string{"lang=zh", "tenant={client_id}-tenant"},
+	}
+
+	opRedirect := authorizeViaBridge(t, "client_id=test_client&redirect_uri=https%3A%2F%2Fexample.com%2Fcallback&response_type=code&scope=openid&state=xyz&nonce=n"+testPKCEQuery)
+	query := opRedirect.Query()
+	if query.Get("app_id") != "test_client" || query.Get("redirect_uri") != "https://example.com/callback" {
+		t.Errorf("Expected placeholders in op_authorize_url to be expanded, got %s", opRedirect.RawQuery)
+	}
+	if query.Has("client_id") || query.Has("response_type") {
+		t.Errorf("Expected renamed-away parameters to be dropped, got %s", opRedirect.RawQuery)
+	}
+	if query.Get("lang") != "zh" || query.Get("tenant") != "test_client-tenant" || query.Get("state") != "xyz" {
+		t.Errorf("Expected extra parameters, got %s", opRedirect.RawQuery)
+	}
+}
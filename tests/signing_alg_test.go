@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeTestKeyPair 将私钥以 PKCS#8、公钥以 PKIX 格式写入临时目录，并更新配置中的密钥路径
+func writeTestKeyPair(t *testing.T, signer crypto.Signer) {
+	dir := t.TempDir()
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	privPath := filepath.Join(dir, "private.key")
+	pubPath := filepath.Join(dir, "public.key")
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+
+	config.Current().PrivateKeyPath = privPath
+	config.Current().PublicKeyPath = pubPath
+}
+
+func TestGenerateIDTokenSigningAlgs(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	cases := []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", rsaKey},
+		{"RS512", rsaKey},
+		{"PS256", rsaKey},
+		{"ES256", p256Key},
+		{"ES384", p384Key},
+		{"EdDSA", edKey},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.alg, func(t *testing.T) {
+			writeTestKeyPair(t, tc.key)
+			config.Current().SigningAlg = tc.alg
+
+			idToken, err := service.GenerateIDToken(config.Current(), "http://localhost:8080", "test_client", "", map[string]interface{}{"sub": "test_user"})
+			if err != nil {
+				t.Fatalf("Failed to generate ID token: %v", err)
+			}
+
+			// 使用公钥校验签名和算法
+			token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+				return tc.key.Public(), nil
+			}, jwt.WithValidMethods([]string{tc.alg}))
+			if err != nil || !token.Valid {
+				t.Errorf("Failed to verify %s ID token: %v", tc.alg, err)
+			}
+		})
+	}
+}
+
+func TestGenerateIDTokenKeyAlgMismatch(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writeTestKeyPair(t, p256Key)
+	config.Current().SigningAlg = "RS256"
+
+	if _, err := service.GenerateIDToken(config.Current(), "http://localhost:8080", "test_client", "", map[string]interface{}{"sub": "test_user"}); err == nil {
+		t.Error("Expected error when signing RS256 with an EC key")
+	}
+}
+
+func TestHandleJWKSEllipticCurveKeys(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	cases := []struct {
+		key      crypto.Signer
+		kty, crv string
+		hasY     bool
+	}{
+		{p256Key, "EC", "P-256", true},
+		{edKey, "OKP", "Ed25519", false},
+	}
+
+	for _, tc := range cases {
+		writeTestKeyPair(t, tc.key)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		handler.HandleJWKS(c)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var jwks model.JWKS
+		if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		key := jwks.Keys[0]
+		if key.KTY != tc.kty || key.Crv != tc.crv {
+			t.Errorf("Expected kty %s crv %s, got kty %s crv %s", tc.kty, tc.crv, key.KTY, key.Crv)
+		}
+		if key.X == "" || (key.Y != "") != tc.hasY {
+			t.Errorf("Unexpected coordinates for %s key: x=%q y=%q", tc.kty, key.X, key.Y)
+		}
+		if key.N != "" || key.E != "" {
+			t.Errorf("Expected no RSA parameters for %s key", tc.kty)
+		}
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 3.1 的示例
+	jwk := model.JWK{
+		KTY: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+
+	kid, err := service.JWKThumbprint(jwk)
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint: %v", err)
+	}
+	if kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("Unexpected RFC 7638 thumbprint: %s", kid)
+	}
+}
+
+func TestHandleJWKSMatchesIDToken(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	writeTestKeyPair(t, rsaKey)
+	config.Current().SigningAlg = "PS256"
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handler.HandleJWKS(c)
+
+	var jwks model.JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	key := jwks.Keys[0]
+	if key.Alg != "PS256" {
+		t.Errorf("Expected alg PS256, got %s", key.Alg)
+	}
+
+	// 1. n 和 e 应能还原出原始公钥
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil || new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 {
+		t.Error("JWK modulus does not match public key")
+	}
+	if key.E != "AQAB" {
+		t.Errorf("Expected exponent AQAB, got %s", key.E)
+	}
+
+	// 2. ID Token 头部的 kid 与 JWKS 一致
+	idToken, err := service.GenerateIDToken(config.Current(), "http://localhost:8080", "test_client", "", map[string]interface{}{"sub": "test_user"})
+	if err != nil {
+		t.Fatalf("Failed to generate ID token: %v", err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(idToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+	if token.Header["kid"] != key.Kid {
+		t.Errorf("Expected ID token kid %s, got %v", key.Kid, token.Header["kid"])
+	}
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errStoreDown = errors.New("connection refused")
+
+// flakyStore 可以模拟连接中断的存储
+type flakyStore struct {
+	service.Store
+	down atomic.Bool
+}
+
+func newFlakyStore() *flakyStore {
+	return &flakyStore{Store: service.NewMemoryStore(service.NewMemoryCache())}
+}
+
+func (s *flakyStore) ping() error {
+	if s.down.Load() {
+		return errStoreDown
+	}
+	return nil
+}
+
+func (s *flakyStore) Set(key, value string, ttl time.Duration) error {
+	if s.down.Load() {
+		return errStoreDown
+	}
+	return s.Store.Set(key, value, ttl)
+}
+
+func (s *flakyStore) Get(key string) (string, error) {
+	if s.down.Load() {
+		return "", errStoreDown
+	}
+	return s.Store.Get(key)
+}
+
+// waitForHealthy 等待后台重连成功
+func waitForHealthy(t *testing.T, store *service.FailoverStore) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !store.Status().Healthy {
+		if time.Now().After(deadline) {
+			t.Fatal("Store did not reconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFailoverStoreFallback(t *testing.T) {
+	primary := newFlakyStore()
+	fallback := service.NewMemoryStore(service.NewMemoryCache())
+	store := service.NewFailoverStore(primary, primary.ping, fallback, 10*time.Millisecond, 20*time.Millisecond)
+	defer store.Close()
+	testStore(t, store)
+
+	// 1. 运行中断开：写入转到本地内存并报告 fallback
+	primary.down.Store(true)
+	if err := store.Set("failover:key", "local", time.Minute); err != nil {
+		t.Fatalf("Expected Set to fall back, got %v", err)
+	}
+	if value, err := fallback.Get("failover:key"); err != nil || value != "local" {
+		t.Errorf("Expected value in fallback store, got %q (err: %v)", value, err)
+	}
+	status := store.Status()
+	if status.Healthy || status.Mode != service.StoreModeFallback || status.Error == "" {
+		t.Errorf("Expected unhealthy fallback status, got %+v", status)
+	}
+
+	// 2. 恢复后切回主存储
+	primary.down.Store(false)
+	waitForHealthy(t, store)
+	if err := store.Set("failover:key", "shared", time.Minute); err != nil {
+		t.Fatalf("Set failed after reconnect: %v", err)
+	}
+	if value, _ := primary.Get("failover:key"); value != "shared" {
+		t.Errorf("Expected value in primary store after reconnect, got %q", value)
+	}
+}
+
+func TestFailoverStoreWithoutFallback(t *testing.T) {
+	primary := newFlakyStore()
+	primary.down.Store(true)
+	store := service.NewFailoverStore(primary, primary.ping, nil, 10*time.Millisecond, 20*time.Millisecond)
+	defer store.Close()
+	store.MarkUnavailable(errStoreDown)
+
+	// 不可用期间不使用本地数据
+	if err := store.Set("failover:key", "value", time.Minute); !errors.Is(err, service.ErrStoreUnavailable) {
+		t.Errorf("Expected ErrStoreUnavailable, got %v", err)
+	}
+	if status := store.Status(); status.Mode != service.StoreModeUnavailable {
+		t.Errorf("Expected unavailable mode, got %s", status.Mode)
+	}
+
+	primary.down.Store(false)
+	waitForHealthy(t, store)
+	if err := store.Set("failover:key", "value", time.Minute); err != nil {
+		t.Errorf("Set failed after reconnect: %v", err)
+	}
+}
+
+func TestInitRedisFailurePolicy(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	originalStore := service.GlobalStore
+	defer func() { service.GlobalStore = originalStore }()
+
+	config.Current().Store = service.StoreRedis
+	config.Current().RedisAddr = "127.0.0.1:1"
+	config.Current().Redis.ReconnectInterval = 60
+
+	// 1. fail：拒绝启动
+	config.Current().Redis.FailurePolicy = service.StoreFailureFail
+	if err := service.InitStore(); err == nil {
+		t.Error("Expected startup to fail when Redis is unreachable")
+	}
+
+	// 2. fallback：使用内存并报告 degraded
+	config.Current().Redis.FailurePolicy = ""
+	if err := service.InitStore(); err != nil {
+		t.Fatalf("Expected fallback to start, got %v", err)
+	}
+	defer service.GlobalStore.(*service.FailoverStore).Close()
+	if err := service.SavePendingTransaction(config.Current(), s256Challenge(testCodeVerifier), service.PKCEMethodS256, &model.AuthTransaction{ClientID: "failover_client", RedirectURI: "https://example.com/callback"}); err != nil {
+		t.Errorf("Expected fallback store to accept writes, got %v", err)
+	}
+	if code, body := getHealth(t); code != http.StatusOK || body["status"] != "degraded" {
+		t.Errorf("Expected 200 degraded, got %d %v", code, body)
+	}
+
+	// 3. degrade：照常启动，依赖存储的请求和健康检查返回 503
+	config.Current().Redis.FailurePolicy = service.StoreFailureDegrade
+	if err := service.InitStore(); err != nil {
+		t.Fatalf("Expected degrade to start, got %v", err)
+	}
+	defer service.GlobalStore.(*service.FailoverStore).Close()
+	if err := service.SavePendingTransaction(config.Current(), s256Challenge(testCodeVerifier), service.PKCEMethodS256, &model.AuthTransaction{ClientID: "failover_client", RedirectURI: "https://example.com/callback"}); !errors.Is(err, service.ErrStoreUnavailable) {
+		t.Errorf("Expected ErrStoreUnavailable, got %v", err)
+	}
+	if code, body := getHealth(t); code != http.StatusServiceUnavailable || body["status"] != "unavailable" {
+		t.Errorf("Expected 503 unavailable, got %d %v", code, body)
+	}
+
+	// 4. 不支持的策略
+	config.Current().Redis.FailurePolicy = "ignore"
+	if err := service.InitStore(); err == nil {
+		t.Error("Expected error for unsupported failure policy")
+	}
+}
+
+func TestHandleHealthMemory(t *testing.T) {
+	originalStore := service.GlobalStore
+	defer func() { service.GlobalStore = originalStore }()
+	service.InitMemoryCache()
+
+	code, body := getHealth(t)
+	if code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("Expected 200 ok, got %d %v", code, body)
+	}
+	if store, _ := body["store"].(map[string]interface{}); store["memory"] == nil {
+		t.Errorf("Expected memory cache statistics, got %v", body["store"])
+	}
+}
+
+func getHealth(t *testing.T) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	handler.HandleHealth(c)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to parse health response: %v", err)
+	}
+	return w.Code, body
+}
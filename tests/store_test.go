@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"oidc-bridge/config"
+	"oidc-bridge/service"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// testStore 检查 Store 实现的通用行为
+func testStore(t *testing.T, store service.Store) {
+	// 1. Set / Get / Delete
+	if err := store.Set("store_test:key", "value", time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := store.Get("store_test:key"); err != nil || value != "value" {
+		t.Errorf("Expected value, got %q (err: %v)", value, err)
+	}
+	if err := store.Delete("store_test:key"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get("store_test:key"); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+
+	// 2. GetDel 只能取出一次
+	if err := store.Set("store_test:once", "value", time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := store.GetDel("store_test:once"); err != nil || value != "value" {
+		t.Errorf("Expected value from GetDel, got %q (err: %v)", value, err)
+	}
+	if _, err := store.GetDel("store_test:once"); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound on second GetDel, got %v", err)
+	}
+
+	// 3. SetNX 只有第一次写入成功
+	defer store.Delete("store_test:nx")
+	if ok, err := store.SetNX("store_test:nx", "first", time.Minute); err != nil || !ok {
+		t.Errorf("Expected first SetNX to succeed, got %v (err: %v)", ok, err)
+	}
+	if ok, err := store.SetNX("store_test:nx", "second", time.Minute); err != nil || ok {
+		t.Errorf("Expected second SetNX to fail, got %v (err: %v)", ok, err)
+	}
+	if value, _ := store.Get("store_test:nx"); value != "first" {
+		t.Errorf("Expected SetNX to keep first value, got %q", value)
+	}
+
+	// 4. Incr 计数并在过期后重新开始
+	defer store.Delete("store_test:counter")
+	for want := int64(1); want <= 3; want++ {
+		if value, err := store.Incr("store_test:counter", 200*time.Millisecond); err != nil || value != want {
+			t.Errorf("Expected counter %d, got %d (err: %v)", want, value, err)
+		}
+	}
+	time.Sleep(300 * time.Millisecond)
+	if value, err := store.Incr("store_test:counter", time.Minute); err != nil || value != 1 {
+		t.Errorf("Expected expired counter to restart at 1, got %d (err: %v)", value, err)
+	}
+
+	// 5. 过期项不可读取，ttl 为 0 时永不过期
+	if err := store.Set("store_test:short", "value", 100*time.Millisecond); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	defer store.Delete("store_test:forever")
+	if err := store.Set("store_test:forever", "value", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := store.Get("store_test:short"); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("Expected expired item to be missing, got %v", err)
+	}
+	if _, err := store.Get("store_test:forever"); err != nil {
+		t.Errorf("Expected item without ttl to persist, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, service.NewMemoryStore(service.NewMemoryCache()))
+}
+
+func TestRedisStore(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: config.Current().RedisAddr})
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis not available, skipping Redis store tests: %v", err)
+	}
+
+	testStore(t, service.NewRedisStore(client, "oidc-bridge-test:"))
+
+	// 所有键都带有前缀
+	store := service.NewRedisStore(client, "oidc-bridge-test:")
+	defer store.Delete("prefixed")
+	if err := store.Set("prefixed", "value", time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if n, err := client.Exists(context.Background(), "oidc-bridge-test:prefixed").Result(); err != nil || n != 1 {
+		t.Errorf("Expected key to be stored with prefix, got %d (err: %v)", n, err)
+	}
+}
+
+func TestSQLStoreSQLite(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "store.db")
+	store, err := service.NewSQLStore(service.SQLDriverSQLite, dsn, 0)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite store: %v", err)
+	}
+	testStore(t, store)
+
+	// 数据在重新打开数据库后保留，重复迁移不报错
+	if err := store.Set("store_test:persist", "value", time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set("store_test:expired", "value", time.Millisecond); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	store.Close()
+
+	store, err = service.NewSQLStore(service.SQLDriverSQLite, dsn, 0)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite store: %v", err)
+	}
+	defer store.Close()
+	if value, err := store.Get("store_test:persist"); err != nil || value != "value" {
+		t.Errorf("Expected value to persist across restarts, got %q (err: %v)", value, err)
+	}
+
+	// 过期数据由后台清理删除，清理后仍可写入同名数据
+	time.Sleep(10 * time.Millisecond)
+	if err := store.DeleteExpired(); err != nil {
+		t.Fatalf("DeleteExpired failed: %v", err)
+	}
+	if ok, err := store.SetNX("store_test:expired", "new", time.Minute); err != nil || !ok {
+		t.Errorf("Expected SetNX to succeed after cleanup, got %v (err: %v)", ok, err)
+	}
+}
+
+func TestSQLStorePostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set, skipping PostgreSQL store tests")
+	}
+
+	// 多个副本同时启动时只有一个执行迁移，每个版本只记录一次
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store, err := service.NewSQLStore(service.SQLDriverPostgres, dsn, 0)
+			if err == nil {
+				store.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Failed to open PostgreSQL store concurrently: %v", err)
+		}
+	}
+
+	store, err := service.NewSQLStore(service.SQLDriverPostgres, dsn, 0)
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL store: %v", err)
+	}
+	defer store.Close()
+	testStore(t, store)
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL: %v", err)
+	}
+	defer db.Close()
+	var duplicates int
+	if err := db.QueryRow(`SELECT COUNT(*) - COUNT(DISTINCT version) FROM oidc_bridge_schema`).Scan(&duplicates); err != nil || duplicates != 0 {
+		t.Errorf("Expected each migration to be recorded once, got %d duplicates (err: %v)", duplicates, err)
+	}
+}
@@ -21,15 +21,15 @@ func SetupTests() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 
 	// 初始化 Redis 客户端
-	if config.AppConfig.RedisAddr != "" {
+	if config.Current().RedisAddr != "" {
 		service.RedisClient = redis.NewClient(&redis.Options{
-			Addr: config.AppConfig.RedisAddr,
+			Addr: config.Current().RedisAddr,
 		})
 	}
 }
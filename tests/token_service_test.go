@@ -20,41 +20,34 @@ func init() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 
 	// 初始化 Redis 客户端
 	service.RedisClient = redis.NewClient(&redis.Options{
-		Addr: config.AppConfig.RedisAddr,
+		Addr: config.Current().RedisAddr,
 	})
 }
 
 func TestGenerateIDToken(t *testing.T) {
 	// 确保密钥文件存在
-	if _, err := os.Stat(config.AppConfig.PrivateKeyPath); os.IsNotExist(err) {
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
 		t.Skip("Private key file not found, skipping ID token generation tests")
 	}
 
 	// 设置测试数据
 	clientID := "test_client"
-	redirectURI := "https://example.com/callback"
 	userInfo := map[string]interface{}{
 		"sub":   "test_user",
 		"name":  "Test User",
 		"email": "test@example.com",
 	}
 
-	// 存储 nonce 到 Redis
-	err := service.SetNonce(clientID, redirectURI, "test_nonce")
-	if err != nil {
-		t.Fatalf("Failed to set nonce: %v", err)
-	}
-
 	// 调用函数
 	issuer := "http://localhost:8080"
-	token, err := service.GenerateIDToken(issuer, clientID, redirectURI, userInfo)
+	token, err := service.GenerateIDToken(config.Current(), issuer, clientID, "test_nonce", userInfo)
 	if err != nil {
 		t.Errorf("Failed to generate ID token: %v", err)
 	}
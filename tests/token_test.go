@@ -9,6 +9,7 @@ import (
 	"oidc-bridge/config"
 	"oidc-bridge/handler"
 	"oidc-bridge/model"
+	"oidc-bridge/service"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -24,8 +25,8 @@ func init() {
 		panic(err)
 	}
 
-	config.AppConfig = &model.Config{}
-	if err := viper.Unmarshal(config.AppConfig); err != nil {
+	config.SetCurrent(&model.Config{})
+	if err := viper.Unmarshal(config.Current()); err != nil {
 		panic(err)
 	}
 }
@@ -61,6 +62,9 @@ func TestHandleToken(t *testing.T) {
 		"scope": {"openid"},
 	}
 
+	// 使用内存缓存替代 Redis 以避免连接问题
+	service.InitMemoryCache()
+
 	// 调用处理函数
 	handler.HandleToken(c)
 
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oidc-bridge/config"
+	"oidc-bridge/handler"
+	"oidc-bridge/model"
+	"oidc-bridge/service"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestPassthroughCodeBindsTransaction(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
+		t.Skip("Private key file not found, skipping transaction tests")
+	}
+
+	op := newFakeOP(t)
+	config.Current().OPTokenURL = op.URL + "/token"
+	config.Current().OPUserInfoURL = op.URL + "/userinfo"
+	service.InitMemoryCache()
+
+	// 1. 同一 client 并发发起两次授权，nonce 和 scope 随各自的 code_challenge 保存
+	otherVerifier := strings.Repeat("v", 43)
+	authorizePassthrough(t, "client_id=txn_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&nonce=txn_nonce", testCodeVerifier)
+	authorizePassthrough(t, "client_id=txn_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&nonce=other_nonce", otherVerifier)
+
+	// 2. RP 按标准流程兑换授权码（不携带 scope），ID Token 应包含本次授权的 nonce
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"txn_code"},
+		"redirect_uri":  {"https://rp.example.com/cb"},
+		"client_id":     {"txn_client"},
+		"code_verifier": {testCodeVerifier},
+	}
+	w := postTokenForm(form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp model.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+	if claims["nonce"] != "txn_nonce" {
+		t.Errorf("Expected nonce txn_nonce, got %v", claims["nonce"])
+	}
+
+	// 3. 重放同一授权码不能再次签发 ID Token
+	w = postTokenForm(form)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected replayed code to be rejected with %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// 4. 授权上下文已被消费，另一次授权的上下文不受影响
+	if _, err := service.TakePendingTransaction("", testCodeVerifier); err == nil {
+		t.Error("Expected pending transaction to be consumed on redemption")
+	}
+	txn, err := service.TakePendingTransaction("", otherVerifier)
+	if err != nil || txn.Nonce != "other_nonce" {
+		t.Errorf("Expected concurrent authorization to keep its own context, got %+v (err: %v)", txn, err)
+	}
+}
+
+func TestPassthroughTokenWithoutContext(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	op := newFakeOP(t)
+	config.Current().OPTokenURL = op.URL + "/token"
+	config.Current().OPUserInfoURL = op.URL + "/userinfo"
+	service.InitMemoryCache()
+
+	// 1. 没有经过 /authorize 的授权码，即使请求中携带 scope=openid 也不能兑换
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"junk_code"},
+		"redirect_uri":  {"https://rp.example.com/cb"},
+		"client_id":     {"txn_client"},
+		"scope":         {"openid"},
+		"code_verifier": {testCodeVerifier},
+	}
+	w := postTokenForm(form)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant without authorization context, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 2. 授权码未被标记为已兑换，RP 仍可在完成授权后兑换
+	if claimed, err := service.ClaimAuthCode(config.Current(), "junk_code"); err != nil || !claimed {
+		t.Errorf("Expected rejected code not to be claimed, got %v (err: %v)", claimed, err)
+	}
+
+	// 3. 不携带 code_verifier 时同样需要经过 /authorize 的上下文
+	delete(form, "code_verifier")
+	if w := postTokenForm(form); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant without authorization context, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 4. require_pkce 开启时 /authorize 必须携带 code_challenge
+	config.Current().RequirePKCE = true
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/authorize?client_id=txn_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&state=s", nil)
+	handler.HandleAuthorize(c)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Host != "rp.example.com" || location.Query().Get("error") != "invalid_request" {
+		t.Errorf("Expected invalid_request redirect to RP, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestPassthroughWithoutPKCE(t *testing.T) {
+	defer setupTestWithConfig("config_test.yaml")()
+	if _, err := os.Stat(config.Current().PrivateKeyPath); os.IsNotExist(err) {
+		t.Skip("Private key file not found, skipping transaction tests")
+	}
+	op := newFakeOP(t)
+	config.Current().OPTokenURL = op.URL + "/token"
+	config.Current().OPUserInfoURL = op.URL + "/userinfo"
+	service.InitMemoryCache()
+
+	// 1. 未开启 require_pkce 时，不使用 PKCE 的 RP 按 client_id 和 redirect_uri 保存授权上下文
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/authorize?client_id=txn_client&redirect_uri=https://rp.example.com/cb&response_type=code&scope=openid&nonce=plain_nonce", nil)
+	handler.HandleAuthorize(c)
+	if w.Code != http.StatusFound || strings.Contains(w.Header().Get("Location"), "error=") {
+		t.Fatalf("Expected redirect to OP, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// 2. ID Token 由授权时保存的 scope 和 nonce 决定，兑换请求中的 scope 不起作用
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"plain_code"},
+		"redirect_uri": {"https://rp.example.com/cb"},
+		"client_id":    {"txn_client"},
+		"scope":        {"profile"},
+	}
+	w = postTokenForm(form)
+	var resp model.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.IDToken == "" {
+		t.Fatalf("Expected ID token, got %d %s", w.Code, w.Body.String())
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims); err != nil || claims["nonce"] != "plain_nonce" {
+		t.Errorf("Expected nonce plain_nonce, got %v (err: %v)", claims["nonce"], err)
+	}
+
+	// 3. 上下文已被消费，同一 RP 的下一个授权码需要再次经过 /authorize
+	form.Set("code", "another_code")
+	if w := postTokenForm(form); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid_grant after context was consumed, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// authorizePassthrough 以 verifier 对应的 S256 code_challenge 在透传模式下发起授权
+func authorizePassthrough(t *testing.T, rawQuery, verifier string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/authorize?"+rawQuery+"&code_challenge="+s256Challenge(verifier)+"&code_challenge_method=S256", nil)
+	handler.HandleAuthorize(c)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status code %d, got %d. Response body: %s", http.StatusFound, w.Code, w.Body.String())
+	}
+}